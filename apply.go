@@ -0,0 +1,209 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Status of an applied plan action
+const (
+	ActionStatusDeleted string = "deleted"
+	ActionStatusSkipped string = "skipped"
+	ActionStatusChanged string = "changed"
+	ActionStatusFailed  string = "failed"
+)
+
+// ApplyResult is the outcome of one plan action
+type ApplyResult struct {
+	Action PlanAction `json:"action"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	Time   time.Time  `json:"time"`
+}
+
+// ApplyReport records what was done with each action of a plan, actions
+// after the first failure are not in the results
+type ApplyReport struct {
+	Version   string        `json:"version"`
+	Plan      string        `json:"plan"`
+	Backup    string        `json:"backup,omitempty"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Results   []ApplyResult `json:"results"`
+}
+
+// confirmPlanAction asks on the terminal whether to delete the object of the action
+func confirmPlanAction(reader *bufio.Reader, action *PlanAction) bool {
+	fmt.Fprintf(os.Stderr, "Delete %s %s (%s) on %s, %s? [y/N] ",
+		action.ObjectType, action.ID, action.Name, action.AZ, action.Reason)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// fetchUnchangedObject fetches the object of the action and checks it is still the
+// one the plan was made for
+func fetchUnchangedObject(vsdSession *VsdSession, action *PlanAction) (*VsdObject, string, error) {
+	identity, err := GetPlanObjectIdentity(action.ObjectType)
+	if err != nil {
+		return nil, ActionStatusFailed, err
+	}
+
+	object, err := vsdSession.FetchObject(identity, action.ID)
+	if err != nil {
+		return nil, ActionStatusFailed, fmt.Errorf("failed to fetch %s %s: %s", action.ObjectType, action.ID, err)
+	}
+	if object.ExternalID() != action.ExternalID {
+		return nil, ActionStatusChanged, fmt.Errorf("externalID of %s %s is %s but %s in plan",
+			action.ObjectType, action.ID, object.ExternalID(), action.ExternalID)
+	}
+	if object.LastUpdatedDate() != action.LastUpdatedDate {
+		return nil, ActionStatusChanged, fmt.Errorf("%s %s was updated at %d after the plan was made at %d",
+			action.ObjectType, action.ID, object.LastUpdatedDate(), action.LastUpdatedDate)
+	}
+	return object, "", nil
+}
+
+// applyPlanAction deletes the object of the action once it checked the object
+// is still the one the plan was made for and backed it up with its children
+func applyPlanAction(action *PlanAction, confirm func(action *PlanAction) bool,
+	backup func(vsdSession *VsdSession, action *PlanAction, object *VsdObject) error) (string, error) {
+	vsd := GetVSDByAZ(globalConfig, action.AZ)
+	if vsd == nil {
+		return ActionStatusFailed, fmt.Errorf("AZ %s was not found in config", action.AZ)
+	}
+	if vsd.URL != action.VsdURL {
+		return ActionStatusFailed, fmt.Errorf("AZ %s is on VSD %s in config but on %s in plan", action.AZ, vsd.URL, action.VsdURL)
+	}
+
+	vsdSession, err := GetVsdSession(vsd)
+	if err != nil {
+		return ActionStatusFailed, err
+	}
+
+	object, status, err := fetchUnchangedObject(vsdSession, action)
+	if err != nil {
+		return status, err
+	}
+
+	if confirm != nil {
+		if !confirm(action) {
+			return ActionStatusSkipped, nil
+		}
+		// The object may have changed while the question was waiting for an answer
+		object, status, err = fetchUnchangedObject(vsdSession, action)
+		if err != nil {
+			return status, err
+		}
+	}
+
+	err = backup(vsdSession, action, object)
+	if err != nil {
+		return ActionStatusFailed, fmt.Errorf("failed to back up %s %s: %s", action.ObjectType, action.ID, err)
+	}
+
+	err = vsdSession.DeleteObject(object)
+	if err != nil {
+		return ActionStatusFailed, fmt.Errorf("failed to delete %s %s: %s", action.ObjectType, action.ID, err)
+	}
+	return ActionStatusDeleted, nil
+}
+
+// startApply runs the actions of a reviewed plan in dependency order and stops on the first error
+func startApply(configPath string, planPath string, options *JobOptions) error {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+
+	globalConfig = config
+
+	plan, err := ReadPlan(planPath)
+	if err != nil {
+		return err
+	}
+	SortPlanActions(plan.Actions)
+
+	var confirm func(action *PlanAction) bool
+	if !options.Yes {
+		reader := bufio.NewReader(os.Stdin)
+		confirm = func(action *PlanAction) bool {
+			return confirmPlanAction(reader, action)
+		}
+	}
+
+	applyReport := &ApplyReport{Version: Version(), Plan: planPath, StartTime: time.Now(), Results: []ApplyResult{}}
+
+	// The bundle is written again after each backup so that it is complete whenever apply stops
+	bundle := NewBackupBundle(planPath, applyReport.StartTime)
+	bundlePath := BackupBundlePath(options.BackupDir, applyReport.StartTime)
+	backup := func(vsdSession *VsdSession, action *PlanAction, object *VsdObject) error {
+		err := bundle.Add(vsdSession, action, object)
+		if err != nil {
+			return err
+		}
+		applyReport.Backup = bundlePath
+		return WriteBackupBundle(bundle, bundlePath)
+	}
+
+	var applyErr error
+	for i := 0; i < len(plan.Actions); i++ {
+		action := &plan.Actions[i]
+		status, err := applyPlanAction(action, confirm, backup)
+		result := ApplyResult{Action: *action, Status: status, Time: time.Now()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		applyReport.Results = append(applyReport.Results, result)
+
+		logrus.WithFields(logrus.Fields{"func": "startApply", "object": action.ObjectType, "status": status}).
+			Info(action.ID)
+		if err != nil {
+			applyErr = fmt.Errorf("action %d of %d: %s", i+1, len(plan.Actions), err)
+			break
+		}
+	}
+	applyReport.EndTime = time.Now()
+
+	err = writeApplyReport(applyReport, options.Output)
+	if err != nil {
+		return fmt.Errorf("failed to write apply results: %s", err)
+	}
+	return applyErr
+}
+
+// writeApplyReport writes the results as indented JSON to the given file, "-" means stdout
+func writeApplyReport(applyReport *ApplyReport, path string) error {
+	data, err := json.MarshalIndent(applyReport, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "" || path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,337 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVsd is a VSD API serving objects kept as JSON attributes, keyed by category
+// and ID, it records the requests which change them
+type fakeVsd struct {
+	mutex    sync.Mutex
+	server   *httptest.Server
+	objects  map[string]map[string]interface{}
+	failures map[string]int
+	changes  []string
+	created  int
+}
+
+func newFakeVsd(t testing.TB) *fakeVsd {
+	f := &fakeVsd{objects: make(map[string]map[string]interface{}), failures: make(map[string]int)}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// vsd returns the config of the fake VSD
+func (f *fakeVsd) vsd() VSD {
+	return VSD{
+		Username:     "csproot",
+		Password:     "csproot",
+		Organization: "csp",
+		URL:          f.server.URL,
+		NetPartition: "OpenStack_bj",
+		CMSID:        "cms-bj",
+		AZ:           "bj",
+	}
+}
+
+// add stores an object of the category under the parent
+func (f *fakeVsd) add(category string, id string, parentType string, parentID string, attributes map[string]interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	object := map[string]interface{}{"ID": id, "parentType": parentType, "parentID": parentID}
+	for name, value := range attributes {
+		object[name] = value
+	}
+	f.objects[category+"/"+id] = object
+}
+
+// fail makes the requests with the method on the object fail with the status
+func (f *fakeVsd) fail(method string, category string, id string, status int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.failures[method+" "+category+"/"+id] = status
+}
+
+func (f *fakeVsd) changeLog() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]string{}, f.changes...)
+}
+
+func writeFakeVsdJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeFakeVsdError(w http.ResponseWriter, status int, description string) {
+	writeFakeVsdJSON(w, status, map[string]interface{}{
+		"errors": []map[string]interface{}{
+			{"property": "", "descriptions": []map[string]string{{"title": http.StatusText(status), "description": description}}},
+		},
+	})
+}
+
+func (f *fakeVsd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/nuage/api/v6/")
+	parts := strings.Split(path, "/")
+	if status := f.failures[r.Method+" "+path]; status != 0 {
+		writeFakeVsdError(w, status, fmt.Sprintf("%s %s failed", r.Method, path))
+		return
+	}
+
+	switch {
+	case path == "me":
+		writeFakeVsdJSON(w, http.StatusOK, []map[string]interface{}{{"ID": "me", "APIKey": "api-key"}})
+	case path == "enterprises":
+		writeFakeVsdJSON(w, http.StatusOK, []map[string]interface{}{{"ID": "enterprise", "name": "OpenStack_bj"}})
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		object := f.objects[path]
+		if object == nil {
+			writeFakeVsdError(w, http.StatusNotFound, path+" was not found")
+			return
+		}
+		writeFakeVsdJSON(w, http.StatusOK, []map[string]interface{}{object})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if f.objects[path] == nil {
+			writeFakeVsdError(w, http.StatusNotFound, path+" was not found")
+			return
+		}
+		delete(f.objects, path)
+		f.changes = append(f.changes, "DELETE "+path)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		var children []map[string]interface{}
+		if r.Header.Get("X-Nuage-Page") == "" || r.Header.Get("X-Nuage-Page") == "0" {
+			for key, object := range f.objects {
+				if strings.HasPrefix(key, parts[2]+"/") && object["parentID"] == parts[1] {
+					children = append(children, object)
+				}
+			}
+		}
+		if len(children) <= 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeFakeVsdJSON(w, http.StatusOK, children)
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		var object map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&object)
+		if err != nil {
+			writeFakeVsdError(w, http.StatusConflict, err.Error())
+			return
+		}
+		f.created++
+		id := fmt.Sprintf("new-%d", f.created)
+		object["ID"] = id
+		object["parentID"] = parts[1]
+		f.objects[parts[2]+"/"+id] = object
+		f.changes = append(f.changes, fmt.Sprintf("POST %s/%s as %s", parts[0], parts[1], parts[2]+"/"+id))
+		writeFakeVsdJSON(w, http.StatusCreated, []map[string]interface{}{object})
+	default:
+		writeFakeVsdError(w, http.StatusNotFound, path+" is not served by the fake VSD")
+	}
+}
+
+// testPlanAction returns the action deleting the vport of the fake VSD
+func testPlanAction(vsd VSD, id string, externalID string, lastUpdatedDate int64) PlanAction {
+	return PlanAction{
+		ObjectType:      "vport",
+		ID:              id,
+		ExternalID:      externalID,
+		LastUpdatedDate: lastUpdatedDate,
+		ParentType:      "subnet",
+		ParentID:        "subnet-1",
+		AZ:              vsd.AZ,
+		VsdURL:          vsd.URL,
+		ResourceType:    ResTypePort,
+		Code:            CodeVPortOrphan,
+	}
+}
+
+// writeTestConfig writes the config of the fake VSD into dir and returns its path
+func writeTestConfig(t *testing.T, fake *fakeVsd, dir string) string {
+	resetVsdSessions()
+	t.Cleanup(func() {
+		resetVsdSessions()
+		globalConfig = nil
+	})
+
+	configPath := filepath.Join(dir, "nuageresscan.json")
+	data, err := json.Marshal(&Config{Vsds: []VSD{fake.vsd()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(configPath, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+// runTestApply applies the plan on the fake VSD without asking and returns the results
+func runTestApply(t *testing.T, fake *fakeVsd, actions []PlanAction) (*ApplyReport, error) {
+	dir := t.TempDir()
+	configPath := writeTestConfig(t, fake, dir)
+	planPath := filepath.Join(dir, "plan.json")
+	err := WritePlan(&Plan{Version: Version(), CreatedTime: time.Now(), Actions: actions}, planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(dir, "apply.json")
+	applyErr := startApply(configPath, planPath, &JobOptions{Yes: true, BackupDir: dir, Output: outputPath})
+
+	data, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyReport := &ApplyReport{}
+	err = json.Unmarshal(data, applyReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return applyReport, applyErr
+}
+
+func applyStatuses(applyReport *ApplyReport) []string {
+	statuses := []string{}
+	for _, result := range applyReport.Results {
+		statuses = append(statuses, result.Action.ID+" "+result.Status)
+	}
+	return statuses
+}
+
+func TestApplyDeletesObject(t *testing.T) {
+	fake := newFakeVsd(t)
+	fake.add("vports", "vport-1", "subnet", "subnet-1", map[string]interface{}{"externalID": "port-1@cms-bj", "lastUpdatedDate": 1600000000000})
+	fake.add("vminterfaces", "vmif-1", "vport", "vport-1", map[string]interface{}{"externalID": "port-1@cms-bj"})
+
+	applyReport, err := runTestApply(t, fake, []PlanAction{testPlanAction(fake.vsd(), "vport-1", "port-1@cms-bj", 1600000000000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses := applyStatuses(applyReport); !reflect.DeepEqual(statuses, []string{"vport-1 deleted"}) {
+		t.Errorf("statuses %q", statuses)
+	}
+	if changes := fake.changeLog(); !reflect.DeepEqual(changes, []string{"DELETE vports/vport-1"}) {
+		t.Errorf("changes %q", changes)
+	}
+
+	bundle, err := ReadBackupBundle(applyReport.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Entries) != 1 || bundle.Entries[0].Object.Attributes["ID"] != "vport-1" {
+		t.Errorf("backup bundle %+v does not hold vport-1", bundle)
+	}
+}
+
+func TestApplySkipsChangedObject(t *testing.T) {
+	for _, action := range []struct {
+		name            string
+		externalID      string
+		lastUpdatedDate int64
+		message         string
+	}{
+		{"externalID", "port-2@cms-bj", 1600000000000, "externalID"},
+		{"lastUpdatedDate", "port-1@cms-bj", 1500000000000, "was updated at"},
+	} {
+		t.Run(action.name, func(t *testing.T) {
+			fake := newFakeVsd(t)
+			fake.add("vports", "vport-1", "subnet", "subnet-1", map[string]interface{}{"externalID": "port-1@cms-bj", "lastUpdatedDate": 1600000000000})
+
+			applyReport, err := runTestApply(t, fake, []PlanAction{testPlanAction(fake.vsd(), "vport-1", action.externalID, action.lastUpdatedDate)})
+			if err == nil || !strings.Contains(err.Error(), action.message) {
+				t.Errorf("apply returned %v, expected an error about the %s", err, action.name)
+			}
+			if statuses := applyStatuses(applyReport); !reflect.DeepEqual(statuses, []string{"vport-1 changed"}) {
+				t.Errorf("statuses %q", statuses)
+			}
+			if changes := fake.changeLog(); len(changes) > 0 {
+				t.Errorf("changes %q, expected none", changes)
+			}
+		})
+	}
+}
+
+func TestApplyStopsAtFirstError(t *testing.T) {
+	fake := newFakeVsd(t)
+	for i := 1; i <= 3; i++ {
+		fake.add("vports", fmt.Sprintf("vport-%d", i), "subnet", "subnet-1",
+			map[string]interface{}{"externalID": fmt.Sprintf("port-%d@cms-bj", i), "lastUpdatedDate": 1600000000000})
+	}
+	fake.fail(http.MethodDelete, "vports", "vport-2", http.StatusConflict)
+
+	var actions []PlanAction
+	for i := 1; i <= 3; i++ {
+		actions = append(actions, testPlanAction(fake.vsd(), fmt.Sprintf("vport-%d", i), fmt.Sprintf("port-%d@cms-bj", i), 1600000000000))
+	}
+	applyReport, err := runTestApply(t, fake, actions)
+	if err == nil || !strings.Contains(err.Error(), "action 2 of 3") {
+		t.Errorf("apply returned %v, expected the error of action 2", err)
+	}
+	if statuses := applyStatuses(applyReport); !reflect.DeepEqual(statuses, []string{"vport-1 deleted", "vport-2 failed"}) {
+		t.Errorf("statuses %q", statuses)
+	}
+	if changes := fake.changeLog(); !reflect.DeepEqual(changes, []string{"DELETE vports/vport-1"}) {
+		t.Errorf("changes %q", changes)
+	}
+}
+
+func TestApplyChecksObjectAfterConfirmation(t *testing.T) {
+	fake := newFakeVsd(t)
+	fake.add("vports", "vport-1", "subnet", "subnet-1", map[string]interface{}{"externalID": "port-1@cms-bj", "lastUpdatedDate": 1600000000000})
+	writeTestConfig(t, fake, t.TempDir())
+	globalConfig = &Config{Vsds: []VSD{fake.vsd()}}
+
+	// The vport is updated while the question is asked
+	confirm := func(action *PlanAction) bool {
+		fake.add("vports", "vport-1", "subnet", "subnet-1", map[string]interface{}{"externalID": "port-1@cms-bj", "lastUpdatedDate": 1700000000000})
+		return true
+	}
+	backup := func(vsdSession *VsdSession, action *PlanAction, object *VsdObject) error {
+		t.Errorf("%s %s was backed up", action.ObjectType, action.ID)
+		return nil
+	}
+	action := testPlanAction(fake.vsd(), "vport-1", "port-1@cms-bj", 1600000000000)
+	status, err := applyPlanAction(&action, confirm, backup)
+	if status != ActionStatusChanged || err == nil || !strings.Contains(err.Error(), "was updated at") {
+		t.Errorf("apply returned %s %v, expected the vport to be changed", status, err)
+	}
+	if changes := fake.changeLog(); len(changes) > 0 {
+		t.Errorf("changes %q, expected none", changes)
+	}
+}
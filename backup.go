@@ -0,0 +1,279 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// VSD object types a backup may refer to, by name
+var backupIdentities = []bambou.Identity{
+	vspk.EnterpriseIdentity,
+	vspk.L2DomainTemplateIdentity,
+	vspk.L2DomainIdentity,
+	vspk.DomainTemplateIdentity,
+	vspk.DomainIdentity,
+	vspk.ZoneIdentity,
+	vspk.SubnetIdentity,
+	vspk.VPortIdentity,
+	vspk.VMIdentity,
+	vspk.VMInterfaceIdentity,
+	vspk.HostInterfaceIdentity,
+	vspk.BridgeInterfaceIdentity,
+	vspk.PolicyGroupIdentity,
+	vspk.FloatingIpIdentity,
+	vspk.IngressACLTemplateIdentity,
+	vspk.EgressACLTemplateIdentity,
+	vspk.IngressACLEntryTemplateIdentity,
+	vspk.EgressACLEntryTemplateIdentity,
+}
+
+// Children saved along with an object, in the order they are restored so that
+// policy groups and floating IPs exist before the objects referring to them
+var backupChildIdentities = map[string][]bambou.Identity{
+	vspk.DomainIdentity.Name: {
+		vspk.PolicyGroupIdentity,
+		vspk.FloatingIpIdentity,
+		vspk.ZoneIdentity,
+		vspk.IngressACLTemplateIdentity,
+		vspk.EgressACLTemplateIdentity,
+	},
+	vspk.L2DomainIdentity.Name: {
+		vspk.PolicyGroupIdentity,
+		vspk.VPortIdentity,
+		vspk.IngressACLTemplateIdentity,
+		vspk.EgressACLTemplateIdentity,
+	},
+	vspk.ZoneIdentity.Name:   {vspk.SubnetIdentity},
+	vspk.SubnetIdentity.Name: {vspk.VPortIdentity},
+	vspk.VPortIdentity.Name: {
+		vspk.VMInterfaceIdentity,
+		vspk.HostInterfaceIdentity,
+		vspk.BridgeInterfaceIdentity,
+	},
+	vspk.IngressACLTemplateIdentity.Name: {vspk.IngressACLEntryTemplateIdentity},
+	vspk.EgressACLTemplateIdentity.Name:  {vspk.EgressACLEntryTemplateIdentity},
+}
+
+// Attributes VSD sets itself, they are not sent back on restore
+var backupReadOnlyAttributes = []string{
+	"ID",
+	"parentID",
+	"parentType",
+	"owner",
+	"creationDate",
+	"lastUpdatedDate",
+	"lastUpdatedBy",
+	"embeddedMetadata",
+}
+
+// BackupObject is the full JSON of a VSD object and of its children
+type BackupObject struct {
+	ObjectType string                 `json:"object_type"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Children   []*BackupObject        `json:"children,omitempty"`
+}
+
+// BackupEntry is a deleted object with the parent to restore it under
+type BackupEntry struct {
+	AZ         string        `json:"az"`
+	VsdURL     string        `json:"vsd_url"`
+	ParentType string        `json:"parent_type"`
+	ParentID   string        `json:"parent_id"`
+	Object     *BackupObject `json:"object"`
+}
+
+// BackupBundle holds the objects deleted by one apply, in the order they were deleted
+type BackupBundle struct {
+	Version     string        `json:"version"`
+	CreatedTime time.Time     `json:"created_time"`
+	Plan        string        `json:"plan"`
+	Entries     []BackupEntry `json:"entries"`
+}
+
+func GetBackupIdentity(objectType string) (bambou.Identity, error) {
+	for _, identity := range backupIdentities {
+		if identity.Name == objectType {
+			return identity, nil
+		}
+	}
+	return bambou.Identity{}, fmt.Errorf("unknown object type %s", objectType)
+}
+
+func NewBackupBundle(plan string, createdTime time.Time) *BackupBundle {
+	return &BackupBundle{Version: Version(), CreatedTime: createdTime, Plan: plan, Entries: []BackupEntry{}}
+}
+
+// BackupBundlePath returns the timestamped path of a new backup bundle in dir
+func BackupBundlePath(dir string, createdTime time.Time) string {
+	return filepath.Join(dir, "nuageresscan-backup-"+createdTime.UTC().Format("20060102T150405Z")+".json")
+}
+
+// backupVsdObject saves the object and, recursively, its children
+func backupVsdObject(vsdSession *VsdSession, object *VsdObject) (*BackupObject, error) {
+	backupObject := &BackupObject{ObjectType: object.Identity().Name, Attributes: object.Attributes}
+	for _, identity := range backupChildIdentities[object.Identity().Name] {
+		children, err := vsdSession.FetchChildObjects(object, identity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s of %s %s: %s", identity.Category, object.Identity().Name, object.ID, err)
+		}
+		for _, child := range children {
+			backupChild, err := backupVsdObject(vsdSession, child)
+			if err != nil {
+				return nil, err
+			}
+			backupObject.Children = append(backupObject.Children, backupChild)
+		}
+	}
+	return backupObject, nil
+}
+
+// Add saves the object of the action with its children into the bundle
+func (b *BackupBundle) Add(vsdSession *VsdSession, action *PlanAction, object *VsdObject) error {
+	backupObject, err := backupVsdObject(vsdSession, object)
+	if err != nil {
+		return err
+	}
+	b.Entries = append(b.Entries, BackupEntry{
+		AZ:         action.AZ,
+		VsdURL:     action.VsdURL,
+		ParentType: action.ParentType,
+		ParentID:   action.ParentID,
+		Object:     backupObject,
+	})
+	return nil
+}
+
+func WriteBackupBundle(bundle *BackupBundle, path string) error {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func ReadBackupBundle(path string) (*BackupBundle, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &BackupBundle{}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	err = decoder.Decode(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse backup bundle %s: %s", path, err)
+	}
+	return bundle, nil
+}
+
+// restoreBackupObject re-creates the object and its children under parent. The
+// restored objects get new IDs, idMap maps the old IDs to the new ones so that
+// attributes referring to a restored object, e.g. locationID, are rewritten.
+func restoreBackupObject(vsdSession *VsdSession, parent *VsdObject, backupObject *BackupObject, idMap map[string]string) error {
+	identity, err := GetBackupIdentity(backupObject.ObjectType)
+	if err != nil {
+		return err
+	}
+
+	oldID, _ := backupObject.Attributes["ID"].(string)
+	object := NewVsdObject(identity, "")
+	object.Attributes = make(map[string]interface{})
+	for name, value := range backupObject.Attributes {
+		if s, ok := value.(string); ok && idMap[s] != "" {
+			value = idMap[s]
+		}
+		object.Attributes[name] = value
+	}
+	for _, name := range backupReadOnlyAttributes {
+		delete(object.Attributes, name)
+	}
+
+	err = vsdSession.CreateObject(parent, object)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s %s under %s %s: %s", identity.Name, oldID, parent.Identity().Name, parent.ID, err)
+	}
+	idMap[oldID] = object.ID
+	logrus.WithFields(logrus.Fields{"func": "restoreBackupObject", "object": identity.Name}).
+		Infof("restored %s as %s", oldID, object.ID)
+
+	for _, child := range backupObject.Children {
+		err = restoreBackupObject(vsdSession, object, child, idMap)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startRestore re-creates the objects of a backup bundle in the reverse order
+// they were deleted, and stops on the first error
+func startRestore(configPath string, bundlePath string) error {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+
+	globalConfig = config
+
+	bundle, err := ReadBackupBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	idMap := make(map[string]string)
+	for i := len(bundle.Entries) - 1; i >= 0; i-- {
+		entry := &bundle.Entries[i]
+		vsd := GetVSDByAZ(globalConfig, entry.AZ)
+		if vsd == nil {
+			return fmt.Errorf("AZ %s was not found in config", entry.AZ)
+		}
+		if vsd.URL != entry.VsdURL {
+			return fmt.Errorf("AZ %s is on VSD %s in config but on %s in backup", entry.AZ, vsd.URL, entry.VsdURL)
+		}
+
+		vsdSession, err := GetVsdSession(vsd)
+		if err != nil {
+			return err
+		}
+
+		parentIdentity, err := GetBackupIdentity(entry.ParentType)
+		if err != nil {
+			return err
+		}
+		parentID := entry.ParentID
+		if idMap[parentID] != "" {
+			parentID = idMap[parentID]
+		}
+
+		err = restoreBackupObject(vsdSession, NewVsdObject(parentIdentity, parentID), entry.Object, idMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
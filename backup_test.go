@@ -0,0 +1,64 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestoreVPortWithInterfaces(t *testing.T) {
+	fake := newFakeVsd(t)
+	fake.add("vports", "vport-1", "subnet", "subnet-1", map[string]interface{}{"externalID": "port-1@cms-bj", "lastUpdatedDate": 1600000000000})
+	fake.add("vminterfaces", "vmif-1", "vport", "vport-1", map[string]interface{}{"externalID": "port-1@cms-bj", "VMUUID": "vm-uuid-1", "VPortID": "vport-1"})
+	fake.add("hostinterfaces", "hostif-1", "vport", "vport-1", map[string]interface{}{"externalID": "port-1@cms-bj", "MAC": "fa:16:3e:00:00:01"})
+
+	applyReport, err := runTestApply(t, fake, []PlanAction{testPlanAction(fake.vsd(), "vport-1", "port-1@cms-bj", 1600000000000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := ReadBackupBundle(applyReport.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var childTypes []string
+	for _, child := range bundle.Entries[0].Object.Children {
+		childTypes = append(childTypes, child.ObjectType)
+	}
+	if !reflect.DeepEqual(childTypes, []string{"vminterface", "hostinterface"}) {
+		t.Errorf("backup of the vport holds %q", childTypes)
+	}
+
+	err = startRestore(writeTestConfig(t, fake, t.TempDir()), applyReport.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"DELETE vports/vport-1",
+		"POST subnets/subnet-1 as vports/new-1",
+		"POST vports/new-1 as vminterfaces/new-2",
+		"POST vports/new-1 as hostinterfaces/new-3",
+	}
+	if changes := fake.changeLog(); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes %q, expected %q", changes, expected)
+	}
+	fake.mutex.Lock()
+	vmInterface := fake.objects["vminterfaces/new-2"]
+	fake.mutex.Unlock()
+	if vmInterface["VPortID"] != "new-1" || vmInterface["VMUUID"] != "vm-uuid-1" {
+		t.Errorf("restored vminterface %v does not point to the restored vport", vmInterface)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Nagios plugin status
+const (
+	CheckOK      int = 0
+	CheckWarning int = 1
+	CheckUnknown int = 3
+)
+
+// CheckResult returns the Nagios plugin status line of the scan and its exit status,
+// the perfdata counts the findings of each resource type and side
+func CheckResult(report *Report, err error) (string, int) {
+	var perfdata []string
+	var counts []string
+	if report != nil {
+		for _, resourceType := range report.ResourceTypes() {
+			sideCounts := make(map[string]int)
+			for _, finding := range report.Findings[resourceType] {
+				sideCounts[finding.Side]++
+			}
+			for _, side := range []string{SideNeutron, SideNuage} {
+				perfdata = append(perfdata, fmt.Sprintf("%s_%s=%d;;;0", resourceType, side, sideCounts[side]))
+			}
+			if report.Suppressed[resourceType] > 0 {
+				perfdata = append(perfdata, fmt.Sprintf("%s_suppressed=%d;;;0", resourceType, report.Suppressed[resourceType]))
+			}
+			if len(report.Findings[resourceType]) > 0 {
+				counts = append(counts, fmt.Sprintf("%s %d", resourceType, len(report.Findings[resourceType])))
+			}
+		}
+	}
+
+	var status string
+	var exitCode int
+	var text string
+	switch {
+	case err != nil:
+		status, exitCode, text = "UNKNOWN", CheckUnknown, err.Error()
+	case report.FindingCount() > 0:
+		status, exitCode = "WARNING", CheckWarning
+		text = fmt.Sprintf("%d inconsistencies (%s)", report.FindingCount(), strings.Join(counts, ", "))
+	default:
+		status, exitCode, text = "OK", CheckOK, "no inconsistency"
+	}
+
+	line := "NUAGERESSCAN " + status + " - " + strings.ReplaceAll(text, "|", "/")
+	if len(perfdata) > 0 {
+		line += " | " + strings.Join(perfdata, " ")
+	}
+	return line, exitCode
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Nuage tables of the Neutron database whose rows point to a Neutron resource
+var cleanupTables = []struct {
+	table       string
+	column      string
+	parentTable string
+}{
+	{"nuage_subnet_l2dom_mapping", "subnet_id", "subnets"},
+	{"newarch_az_router_nuage", "router_id", "routers"},
+}
+
+// DanglingRows are the rows of Table whose Column points to a row of ParentTable
+// that no longer exists
+type DanglingRows struct {
+	Table       string
+	Column      string
+	ParentTable string
+	IDs         []string
+	Count       int
+}
+
+func findDanglingRows() ([]DanglingRows, error) {
+	var allDanglingRows []DanglingRows
+	for _, cleanupTable := range cleanupTables {
+		logrus.WithField("func", "findDanglingRows").
+			Info("SelectDanglingColumnValues from " + cleanupTable.table)
+		var values []string
+		err := SelectDanglingColumnValues(&values, cleanupTable.table, cleanupTable.column, cleanupTable.parentTable)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) <= 0 {
+			continue
+		}
+
+		danglingRows := DanglingRows{Table: cleanupTable.table, Column: cleanupTable.column, ParentTable: cleanupTable.parentTable, Count: len(values)}
+		seen := make(map[string]bool)
+		for _, value := range values {
+			if !seen[value] {
+				seen[value] = true
+				danglingRows.IDs = append(danglingRows.IDs, value)
+			}
+		}
+		sort.Strings(danglingRows.IDs)
+		allDanglingRows = append(allDanglingRows, danglingRows)
+	}
+
+	return allDanglingRows, nil
+}
+
+// condition selects the reviewed rows only, and only while their parent is still missing.
+// The values are inlined as SQL literals when literal is set, else passed as arguments.
+func (d *DanglingRows) condition(literal bool) (string, []interface{}) {
+	var values []string
+	var args []interface{}
+	for _, id := range d.IDs {
+		if literal {
+			values = append(values, "'"+strings.ReplaceAll(id, "'", "''")+"'")
+		} else {
+			values = append(values, "?")
+			args = append(args, id)
+		}
+	}
+	condition := fmt.Sprintf("%[1]s in (%[2]s) and %[1]s not in (select id from %[3]s)", d.Column, strings.Join(values, ", "), d.ParentTable)
+	return condition, args
+}
+
+// Name of the procedure the cleanup script runs its deletes in
+const cleanupProcedure = "nuageresscan_cleanup"
+
+// WriteCleanupSQL writes a script deleting the dangling rows in one transaction.
+// The rows to delete are selected first for review, then the deletes run in a
+// procedure which checks ROW_COUNT() after each of them and rolls back everything
+// if a table has not exactly the expected number of rows deleted.
+func WriteCleanupSQL(w io.Writer, allDanglingRows []DanglingRows, createdTime time.Time) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Generated by nuageresscan %s at %s\n", Version(), createdTime.Format(time.RFC3339))
+	fmt.Fprintf(&b, "-- Review the rows returned by each select before running the script\n")
+	for i := 0; i < len(allDanglingRows); i++ {
+		danglingRows := &allDanglingRows[i]
+		condition, _ := danglingRows.condition(true)
+		fmt.Fprintf(&b, "\n-- %d rows of %s whose %s.id was not found\n", danglingRows.Count, danglingRows.Table, danglingRows.ParentTable)
+		fmt.Fprintf(&b, "select * from %s where %s;\n", danglingRows.Table, condition)
+	}
+
+	fmt.Fprintf(&b, "\ndrop procedure if exists %s;\n", cleanupProcedure)
+	fmt.Fprintf(&b, "delimiter //\n")
+	fmt.Fprintf(&b, "create procedure %s()\n", cleanupProcedure)
+	fmt.Fprintf(&b, "begin\n")
+	fmt.Fprintf(&b, "  declare deleted int;\n")
+	fmt.Fprintf(&b, "  declare exit handler for sqlexception begin rollback; resignal; end;\n")
+	fmt.Fprintf(&b, "  start transaction;\n")
+	for i := 0; i < len(allDanglingRows); i++ {
+		danglingRows := &allDanglingRows[i]
+		condition, _ := danglingRows.condition(true)
+		fmt.Fprintf(&b, "\n  delete from %s where %s;\n", danglingRows.Table, condition)
+		fmt.Fprintf(&b, "  set deleted = row_count();\n")
+		fmt.Fprintf(&b, "  if deleted <> %d then\n", danglingRows.Count)
+		fmt.Fprintf(&b, "    signal sqlstate '45000' set message_text = 'deleted rows from %s differ from the expected %d, rolled back';\n",
+			danglingRows.Table, danglingRows.Count)
+		fmt.Fprintf(&b, "  end if;\n")
+	}
+	fmt.Fprintf(&b, "\n  commit;\n")
+	fmt.Fprintf(&b, "end//\n")
+	fmt.Fprintf(&b, "delimiter ;\n")
+	fmt.Fprintf(&b, "call %s();\n", cleanupProcedure)
+	fmt.Fprintf(&b, "drop procedure %s;\n", cleanupProcedure)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// executeCleanup deletes the dangling rows in one transaction, which is rolled
+// back if any table has not exactly the expected number of rows to delete
+func executeCleanup(allDanglingRows []DanglingRows) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(allDanglingRows); i++ {
+		danglingRows := &allDanglingRows[i]
+		condition, args := danglingRows.condition(false)
+
+		var count int
+		err = tx.Get(&count, fmt.Sprintf("select count(*) from %s where %s for update", danglingRows.Table, condition), args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if count != danglingRows.Count {
+			tx.Rollback()
+			return fmt.Errorf("found %d dangling rows in %s but expected %d, rolled back", count, danglingRows.Table, danglingRows.Count)
+		}
+
+		result, err := tx.Exec(fmt.Sprintf("delete from %s where %s", danglingRows.Table, condition), args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if int(deleted) != danglingRows.Count {
+			tx.Rollback()
+			return fmt.Errorf("deleted %d rows from %s but expected %d, rolled back", deleted, danglingRows.Table, danglingRows.Count)
+		}
+
+		logrus.WithFields(logrus.Fields{"func": "executeCleanup", "object": "neutron"}).
+			Infof("deleted %d dangling rows from %s", deleted, danglingRows.Table)
+	}
+
+	return tx.Commit()
+}
+
+// startCleanup writes the cleanup script of the dangling Nuage rows of the
+// Neutron database to sqlPath, and runs it too if options.Execute is set
+func startCleanup(configPath string, sqlPath string, options *JobOptions) error {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+
+	globalConfig = config
+
+	neu := globalConfig.Neu
+	err = OpenDB(neu.Username, neu.Password, neu.IPAddr, neu.Port, neu.DBName)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %s", err)
+	}
+
+	allDanglingRows, err := findDanglingRows()
+	if err != nil {
+		return fmt.Errorf("failed to find dangling rows: %s", err)
+	}
+
+	if sqlPath == "-" {
+		err = WriteCleanupSQL(os.Stdout, allDanglingRows, time.Now())
+	} else {
+		var file *os.File
+		file, err = os.Create(sqlPath)
+		if err == nil {
+			err = WriteCleanupSQL(file, allDanglingRows, time.Now())
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write cleanup script: %s", err)
+	}
+
+	if !options.Execute {
+		return nil
+	}
+	err = executeCleanup(allDanglingRows)
+	if err != nil {
+		return fmt.Errorf("failed to execute cleanup: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCleanupSQLChecksRowCount(t *testing.T) {
+	allDanglingRows := []DanglingRows{
+		{Table: "nuage_subnet_l2dom_mapping", Column: "subnet_id", ParentTable: "subnets", IDs: []string{"s1", "s'2"}, Count: 3},
+		{Table: "newarch_az_router_nuage", Column: "router_id", ParentTable: "routers", IDs: []string{"r1"}, Count: 1},
+	}
+
+	var b strings.Builder
+	err := WriteCleanupSQL(&b, allDanglingRows, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := b.String()
+
+	condition := "subnet_id in ('s1', 's''2') and subnet_id not in (select id from subnets)"
+	expected := []string{
+		"select * from nuage_subnet_l2dom_mapping where " + condition + ";",
+		"declare exit handler for sqlexception begin rollback; resignal; end;",
+		"delete from nuage_subnet_l2dom_mapping where " + condition + ";\n  set deleted = row_count();\n  if deleted <> 3 then\n    signal",
+		"delete from newarch_az_router_nuage where router_id in ('r1') and router_id not in (select id from routers);\n  set deleted = row_count();\n  if deleted <> 1 then\n    signal",
+		"call nuageresscan_cleanup();",
+	}
+	for _, statement := range expected {
+		if !strings.Contains(script, statement) {
+			t.Errorf("script does not contain %q:\n%s", statement, script)
+		}
+	}
+	if strings.Index(script, "select * from newarch_az_router_nuage") > strings.Index(script, "create procedure") {
+		t.Errorf("the rows to review are not selected before the deletes:\n%s", script)
+	}
+}
@@ -0,0 +1,577 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const defaultConfigPath = "nuageresscan.json"
+
+// commandLine holds the flags of one command, the flags with a short alias are
+// registered under both names
+type commandLine struct {
+	command      *Command
+	flagSet      *flag.FlagSet
+	shortNames   map[string]string
+	options      JobOptions
+	configPath   string
+	resourceType string
+	azs          string
+	vsds         string
+	ids          string
+	logLevel     string
+	planPath     string
+	sqlPath      string
+	bundlePath   string
+}
+
+// Command is a subcommand, setup registers its flags and run runs it with the
+// positional arguments and returns the exit status
+type Command struct {
+	Name        string
+	Args        string
+	Description string
+	setup       func(c *commandLine)
+	run         func(c *commandLine, args []string) int
+}
+
+func (c *commandLine) stringFlag(p *string, name string, shortName string, value string, usage string) {
+	c.flagSet.StringVar(p, name, value, usage)
+	if shortName != "" {
+		c.flagSet.StringVar(p, shortName, value, usage)
+		c.shortNames[name] = shortName
+	}
+}
+
+func (c *commandLine) boolFlag(p *bool, name string, shortName string, usage string) {
+	c.flagSet.BoolVar(p, name, false, usage)
+	if shortName != "" {
+		c.flagSet.BoolVar(p, shortName, false, usage)
+		c.shortNames[name] = shortName
+	}
+}
+
+func (c *commandLine) configFlag() {
+	c.stringFlag(&c.configPath, "config", "c", defaultConfigPath, "config `file`")
+}
+
+func (c *commandLine) outputFlags(usage string) {
+	c.stringFlag(&c.options.Output, "output", "o", "", usage)
+	c.stringFlag(&c.options.Format, "format", "f", FormatJSON,
+		fmt.Sprintf("report `format`: %s, %s or %s", FormatJSON, FormatCSV, FormatMarkdown))
+}
+
+func (c *commandLine) scanFlags() {
+	c.stringFlag(&c.resourceType, "resource-type", "t", ResTypeAll,
+		fmt.Sprintf("`types` to scan separated by commas: %s", strings.Join(resourceTypeNames(), ", ")))
+	c.filterFlags()
+	c.flagSet.IntVar(&c.options.Concurrency, "concurrency", 0, "maximum `number` of concurrent requests sent to one VSD (default concurrency of config)")
+	c.stringFlag(&c.options.Previous, "previous", "", "",
+		"json `report` of the previous run, findings it has keep their first_seen "+
+			"and the findings it has not are sent to the notify section of config")
+	c.stringFlag(&c.options.Suppressions, "suppressions", "", "", "hide the accepted findings listed in `file` from the report")
+}
+
+func (c *commandLine) filterFlags() {
+	c.stringFlag(&c.azs, "az", "", "", "only scan the VSDs of the `AZs` separated by commas")
+	c.stringFlag(&c.vsds, "vsd", "", "", "only scan the VSDs of the `URLs` separated by commas")
+	c.stringFlag(&c.options.Filter.Project, "project", "", "", "only scan the objects of the Neutron `project`")
+	c.stringFlag(&c.ids, "id", "", "",
+		"only scan the objects of the `IDs` separated by commas, and their related objects, "+
+			"the IDs are Neutron IDs or VSD IDs of subnets, l2domains and domains")
+}
+
+func resourceTypeNames() []string {
+	var names []string
+	for _, scanner := range scanners {
+		names = append(names, scanner.resourceType)
+	}
+	return append(names, ResTypeAll)
+}
+
+// commands in the order of the help
+var commands []*Command
+
+func init() {
+	commands = []*Command{
+		{
+			Name:        "scan",
+			Args:        "[resource type...]",
+			Description: "scan the resource types for inconsistencies between Neutron and the VSDs",
+			setup: func(c *commandLine) {
+				c.configFlag()
+				c.scanFlags()
+				c.outputFlags("write the report to `file`, \"-\" for stdout (default stdout when --format is given)")
+				c.stringFlag(&c.options.FromSnapshot, "from-snapshot", "", "", "scan the snapshot `file` instead of the Neutron database and the VSDs")
+				c.stringFlag(&c.options.MetricsTextfile, "metrics-textfile", "", "", "write the Prometheus metrics of the scan to `file` for node_exporter")
+				c.stringFlag(&c.options.MetricsListen, "metrics-listen", "", "", "keep serving the Prometheus metrics of the scan on `addr`/metrics")
+				c.boolFlag(&c.options.Check, "check", "", "print one Nagios plugin status line with perfdata and exit with the plugin status: 0 OK, 1 WARNING, 3 UNKNOWN")
+			},
+			run: runScan,
+		},
+		{
+			Name:        "plan",
+			Args:        "[resource type...]",
+			Description: "scan the resource types and write the deletes of the orphan VSD objects to a plan file for review",
+			setup: func(c *commandLine) {
+				c.configFlag()
+				c.stringFlag(&c.planPath, "plan-file", "p", "", "write the plan to `file` (required)")
+				c.scanFlags()
+				c.outputFlags("write the report to `file`, \"-\" for stdout (default stdout when --format is given)")
+			},
+			run: runPlan,
+		},
+		{
+			Name: "apply",
+			Description: "run the deletes of a reviewed plan file, asking before each one unless --yes is given, " +
+				"deleted objects are saved with their children to a backup file",
+			setup: func(c *commandLine) {
+				c.configFlag()
+				c.stringFlag(&c.planPath, "plan-file", "p", "", "plan `file` written by plan (required)")
+				c.boolFlag(&c.options.Yes, "yes", "y", "delete without asking")
+				c.stringFlag(&c.options.BackupDir, "backup-dir", "b", ".", "`directory` of the backup files")
+				c.stringFlag(&c.options.Output, "output", "o", "-", "write the result of each action to `file`, \"-\" for stdout")
+			},
+			run: runApply,
+		},
+		{
+			Name:        "cleanup",
+			Description: "write the SQL deleting the Nuage rows of the Neutron database which refer to deleted subnets and routers",
+			setup: func(c *commandLine) {
+				c.configFlag()
+				c.stringFlag(&c.sqlPath, "sql-file", "s", "-", "write the SQL to `file`, \"-\" for stdout")
+				c.boolFlag(&c.options.Execute, "execute", "", "run the SQL too")
+			},
+			run: runCleanup,
+		},
+		{
+			Name:        "restore",
+			Description: "re-create the objects of a backup file written by apply",
+			setup: func(c *commandLine) {
+				c.configFlag()
+				c.stringFlag(&c.bundlePath, "backup-file", "", "", "backup `file` written by apply (required)")
+			},
+			run: runRestore,
+		},
+		{
+			Name:        "snapshot",
+			Description: "write everything the scanners read from the Neutron database and the VSDs to a snapshot file, to scan it later with scan --from-snapshot",
+			setup: func(c *commandLine) {
+				c.configFlag()
+				c.stringFlag(&c.options.Output, "output", "o", "", "write the snapshot to `file` (required)")
+				c.flagSet.IntVar(&c.options.Concurrency, "concurrency", 0, "maximum `number` of concurrent requests sent to one VSD (default concurrency of config)")
+			},
+			run: runSnapshot,
+		},
+		{
+			Name:        "diff",
+			Args:        "<old report> <new report>",
+			Description: "write the findings added, removed and unchanged between two json reports, with how long each one has persisted",
+			setup: func(c *commandLine) {
+				c.outputFlags("write the diff to `file`, \"-\" for stdout")
+			},
+			run: runDiff,
+		},
+		{
+			Name:        "serve",
+			Description: "scan on the schedule of the serve section of config and serve the results, the history and /metrics over HTTP",
+			setup: func(c *commandLine) {
+				c.configFlag()
+				c.filterFlags()
+				c.flagSet.IntVar(&c.options.Concurrency, "concurrency", 0, "maximum `number` of concurrent requests sent to one VSD (default concurrency of config)")
+				c.stringFlag(&c.options.Suppressions, "suppressions", "", "", "hide the accepted findings listed in `file` from the reports")
+				c.stringFlag(&c.options.MetricsTextfile, "metrics-textfile", "", "", "write the Prometheus metrics of each scan to `file` for node_exporter")
+			},
+			run: runServe,
+		},
+		{
+			Name:        "doctor",
+			Description: "check the config, the connection to the Neutron database and the login to each VSD",
+			setup: func(c *commandLine) {
+				c.configFlag()
+			},
+			run: runDoctor,
+		},
+		{
+			Name:        "version",
+			Description: "show program version",
+			run: func(c *commandLine, args []string) int {
+				fmt.Println("nuageresscan version", Version())
+				return ExitClean
+			},
+		},
+		{
+			Name:        "help",
+			Args:        "[command]",
+			Description: "show the help of the program or of a command",
+			run: func(c *commandLine, args []string) int {
+				if len(args) > 0 {
+					command := findCommand(args[0])
+					if command == nil {
+						return unknownCommand(args[0])
+					}
+					newCommandLine(command).printUsage(os.Stdout)
+					return ExitClean
+				}
+				printUsage(os.Stdout)
+				return ExitClean
+			},
+		},
+	}
+}
+
+func findCommand(name string) *Command {
+	for _, command := range commands {
+		if command.Name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+func newCommandLine(command *Command) *commandLine {
+	c := &commandLine{
+		command:    command,
+		flagSet:    flag.NewFlagSet(command.Name, flag.ContinueOnError),
+		shortNames: make(map[string]string),
+	}
+	c.flagSet.SetOutput(io.Discard)
+	if command.setup != nil {
+		command.setup(c)
+	}
+	c.stringFlag(&c.logLevel, "log-level", "l", "warning", "log `level`: debug, info, warning or error")
+	return c
+}
+
+// printUsage writes the help of the program, generated from the commands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage:\n  nuageresscan <command> [flags]\n\nCommands:\n")
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", command.Name, strings.Join(wrapText(command.Description, 64), "\n"+strings.Repeat(" ", 13)))
+	}
+	fmt.Fprintf(w, "\nRun \"nuageresscan help <command>\" for the flags of a command.\n\n"+
+		"Resource types:\n  %s\n\n"+
+		"Exit status:\n"+
+		"  %d  no inconsistency was found\n"+
+		"  %d  inconsistencies were found\n"+
+		"  %d  the command failed\n",
+		strings.Join(resourceTypeNames(), ", "), ExitClean, ExitInconsistent, ExitFailed)
+}
+
+// printUsage writes the help of the command, generated from its flags
+func (c *commandLine) printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage:\n  nuageresscan %s [flags]", c.command.Name)
+	if c.command.Args != "" {
+		fmt.Fprintf(w, " %s", c.command.Args)
+	}
+	fmt.Fprintf(w, "\n\n%s\n\nFlags:\n", strings.Join(wrapText(c.command.Description, 78), "\n"))
+
+	isShortName := make(map[string]bool)
+	for _, shortName := range c.shortNames {
+		isShortName[shortName] = true
+	}
+	var names []string
+	c.flagSet.VisitAll(func(f *flag.Flag) {
+		if !isShortName[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		f := c.flagSet.Lookup(name)
+		valueName, usage := flag.UnquoteUsage(f)
+
+		left := "      --" + name
+		if shortName := c.shortNames[name]; shortName != "" {
+			left = "  -" + shortName + ", --" + name
+		}
+		if valueName != "" {
+			left += " <" + valueName + ">"
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		usage = strings.Join(wrapText(usage, 48), "\n"+strings.Repeat(" ", 30))
+		if len(left) > 28 {
+			fmt.Fprintf(w, "%s\n%30s%s\n", left, "", usage)
+		} else {
+			fmt.Fprintf(w, "%-28s  %s\n", left, usage)
+		}
+	}
+}
+
+// wrapText splits the text in lines of at most width characters, except for longer words
+func wrapText(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
+
+// parse parses the flags, which may come before, after or between the positional
+// arguments, and returns the positional arguments
+func (c *commandLine) parse(args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := c.flagSet.Parse(args)
+		if err != nil && strings.HasPrefix(err.Error(), "flag provided but not defined: ") {
+			name := strings.TrimLeft(strings.TrimPrefix(err.Error(), "flag provided but not defined: "), "-")
+			if len(name) > 1 {
+				return nil, fmt.Errorf("unknown flag --%s", name)
+			}
+			return nil, fmt.Errorf("unknown flag -%s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = c.flagSet.Args()
+		if len(args) <= 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	level, err := logrus.ParseLevel(c.logLevel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %s", c.logLevel)
+	}
+	logrus.SetLevel(level)
+
+	if reportWriters[c.options.Format] == nil && c.options.Format != "" {
+		return nil, fmt.Errorf("unknown report format %s", c.options.Format)
+	}
+	if c.options.Concurrency < 0 {
+		return nil, fmt.Errorf("invalid concurrency %d", c.options.Concurrency)
+	}
+	if c.azs != "" {
+		c.options.Filter.AZs = strings.Split(c.azs, ",")
+	}
+	if c.vsds != "" {
+		c.options.Filter.Vsds = strings.Split(c.vsds, ",")
+	}
+	if c.ids != "" {
+		c.options.Filter.IDs = strings.Split(c.ids, ",")
+	}
+	return positional, nil
+}
+
+// isSet returns whether the flag was given on the command line, under its long or short name
+func (c *commandLine) isSet(name string) bool {
+	set := false
+	c.flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == name || f.Name == c.shortNames[name] {
+			set = true
+		}
+	})
+	return set
+}
+
+func unknownCommand(name string) int {
+	fmt.Fprintf(os.Stderr, "nuageresscan: unknown command %q\nRun \"nuageresscan help\" for the commands.\n", name)
+	return ExitFailed
+}
+
+// usageError reports a wrong command line of the command
+func (c *commandLine) usageError(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "nuageresscan %s: %s\nRun \"nuageresscan help %s\" for the flags.\n",
+		c.command.Name, fmt.Sprintf(format, a...), c.command.Name)
+	return ExitFailed
+}
+
+// runCommandLine runs the command of the arguments and returns the exit status
+func runCommandLine(args []string) int {
+	if len(args) <= 0 {
+		printUsage(os.Stderr)
+		return ExitFailed
+	}
+	switch args[0] {
+	case "-h", "--help":
+		printUsage(os.Stdout)
+		return ExitClean
+	case "-v", "--version":
+		args[0] = "version"
+	}
+
+	command := findCommand(args[0])
+	if command == nil {
+		return unknownCommand(args[0])
+	}
+	c := newCommandLine(command)
+	positional, err := c.parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		c.printUsage(os.Stdout)
+		return ExitClean
+	}
+	if err != nil {
+		return c.usageError("%s", err)
+	}
+	return command.run(c, positional)
+}
+
+func logError(err error) int {
+	logrus.WithField("func", "main").Error(err)
+	return ExitFailed
+}
+
+func runScan(c *commandLine, args []string) int {
+	if len(args) > 0 {
+		if c.isSet("resource-type") {
+			return c.usageError("resource types are given both as arguments and with --resource-type")
+		}
+		c.resourceType = strings.Join(args, ",")
+	}
+	if c.isSet("format") && c.options.Output == "" {
+		c.options.Output = "-"
+	}
+	if c.isSet("from-snapshot") && c.isSet("config") {
+		return c.usageError("a snapshot has its own config, --config cannot be given with --from-snapshot")
+	}
+	for _, resourceType := range strings.Split(c.resourceType, ",") {
+		if !isResourceType(resourceType) {
+			return c.usageError("unknown resource type %s", resourceType)
+		}
+	}
+
+	report, err := startJob(c.configPath, c.resourceType, &c.options)
+	if c.options.MetricsTextfile != "" {
+		metricsErr := WriteMetricsTextfile(report, c.options.MetricsTextfile)
+		if metricsErr != nil {
+			logrus.WithField("func", "main").Error("failed to write metrics: ", metricsErr)
+		}
+	}
+	if c.options.MetricsListen != "" {
+		if err != nil {
+			logrus.WithField("func", "main").Error(err)
+		}
+		SetMetricsReport(report)
+		return logError(ServeMetrics(c.options.MetricsListen))
+	}
+	if c.options.Check {
+		line, exitCode := CheckResult(report, err)
+		fmt.Println(line)
+		return exitCode
+	}
+	if err != nil {
+		return logError(err)
+	}
+	if report.FindingCount() > 0 {
+		return ExitInconsistent
+	}
+	return ExitClean
+}
+
+func runPlan(c *commandLine, args []string) int {
+	if c.planPath == "" {
+		return c.usageError("--plan-file is required")
+	}
+	c.options.Plan = c.planPath
+	return runScan(c, args)
+}
+
+func noArgs(c *commandLine, args []string) bool {
+	if len(args) > 0 {
+		c.usageError("unexpected argument %s", args[0])
+		return false
+	}
+	return true
+}
+
+// runAction runs a command which only fails or succeeds
+func runAction(err error) int {
+	if err != nil {
+		return logError(err)
+	}
+	return ExitClean
+}
+
+func runApply(c *commandLine, args []string) int {
+	if !noArgs(c, args) {
+		return ExitFailed
+	}
+	if c.planPath == "" {
+		return c.usageError("--plan-file is required")
+	}
+	return runAction(startApply(c.configPath, c.planPath, &c.options))
+}
+
+func runCleanup(c *commandLine, args []string) int {
+	if !noArgs(c, args) {
+		return ExitFailed
+	}
+	return runAction(startCleanup(c.configPath, c.sqlPath, &c.options))
+}
+
+func runRestore(c *commandLine, args []string) int {
+	if !noArgs(c, args) {
+		return ExitFailed
+	}
+	if c.bundlePath == "" {
+		return c.usageError("--backup-file is required")
+	}
+	return runAction(startRestore(c.configPath, c.bundlePath))
+}
+
+func runSnapshot(c *commandLine, args []string) int {
+	if !noArgs(c, args) {
+		return ExitFailed
+	}
+	if c.options.Output == "" {
+		return c.usageError("--output is required")
+	}
+	return runAction(startSnapshot(c.configPath, c.options.Output, &c.options))
+}
+
+func runDiff(c *commandLine, args []string) int {
+	if len(args) != 2 {
+		return c.usageError("diff needs the old and the new report")
+	}
+	return runAction(startDiff(args[0], args[1], &c.options))
+}
+
+func runServe(c *commandLine, args []string) int {
+	if !noArgs(c, args) {
+		return ExitFailed
+	}
+	return logError(startServe(c.configPath, &c.options))
+}
+
+func runDoctor(c *commandLine, args []string) int {
+	if !noArgs(c, args) {
+		return ExitFailed
+	}
+	if !startDoctor(c.configPath, os.Stdout) {
+		return ExitFailed
+	}
+	return ExitClean
+}
@@ -17,7 +17,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
 )
 
 /*
@@ -48,7 +51,28 @@ import (
       "cms_id": "e4e6b7b1-202a-4d7a-87f2-412beb513d17",
       "az": "changsha"
     }
-  ]
+  ],
+  "concurrency": 4,
+  "serve": {
+    "listen": ":9470",
+    "interval": "6h",
+    "resource_types": ["all"],
+    "history": 28,
+    "history_dir": "/var/lib/nuageresscan"
+  },
+  "notify": {
+    "webhook": {
+      "url": "https://alerts.example.com/hooks/nuageresscan",
+      "headers": {"Authorization": "Bearer 1b6e9c0f"},
+      "template": ""
+    },
+    "syslog": {
+      "network": "udp",
+      "address": "135.251.96.10:514",
+      "facility": "local0",
+      "template": ""
+    }
+  }
 }
 */
 
@@ -70,11 +94,56 @@ type VSD struct {
 	AZ           string `json:"az"`
 }
 
+// ServeConfig is the schedule of serve, the reports are kept in history_dir
+// when it is set and only in memory otherwise
+type ServeConfig struct {
+	Listen        string   `json:"listen"`
+	Interval      string   `json:"interval"`
+	ResourceTypes []string `json:"resource_types"`
+	History       int      `json:"history"`
+	HistoryDir    string   `json:"history_dir"`
+}
+
+// WebhookConfig is where the new findings are posted, template is a text/template
+// of the body and the JSON payload is posted when it is empty
+type WebhookConfig struct {
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	Template string            `json:"template"`
+}
+
+// SyslogConfig is where the new findings are sent as RFC 5424 messages, one per
+// finding, template is a text/template of the message of a finding
+type SyslogConfig struct {
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Facility string `json:"facility"`
+	Template string `json:"template"`
+}
+
+type NotifyConfig struct {
+	Webhook *WebhookConfig `json:"webhook"`
+	Syslog  *SyslogConfig  `json:"syslog"`
+}
+
 type Config struct {
-	Neu  Neutron `json:"neutron"`
-	Vsds []VSD   `json:"vsd"`
+	Neu         Neutron      `json:"neutron"`
+	Vsds        []VSD        `json:"vsd"`
+	Concurrency int          `json:"concurrency"`
+	Serve       ServeConfig  `json:"serve"`
+	Notify      NotifyConfig `json:"notify"`
 }
 
+// Number of concurrent requests sent to one VSD when concurrency is not set
+const defaultConcurrency = 4
+
+// Defaults of the serve section
+const (
+	defaultServeListen   = ":9470"
+	defaultServeInterval = "24h"
+	defaultServeHistory  = 30
+)
+
 func LoadConfig(configPath string) (*Config, error) {
 	buf, err := ioutil.ReadFile(configPath)
 
@@ -100,3 +169,75 @@ func GetCMSID(config *Config, az string) string {
 
 	return ""
 }
+
+func GetVSDByExternalID(config *Config, externalID string) *VSD {
+	parts := strings.Split(externalID, "@")
+	if len(parts) < 2 {
+		return nil
+	}
+
+	for i := 0; i < len(config.Vsds); i++ {
+		if config.Vsds[i].CMSID == parts[1] {
+			return &config.Vsds[i]
+		}
+	}
+
+	return nil
+}
+
+func GetVSDByAZ(config *Config, az string) *VSD {
+	for i := 0; i < len(config.Vsds); i++ {
+		if config.Vsds[i].AZ == az {
+			return &config.Vsds[i]
+		}
+	}
+
+	return nil
+}
+
+// GetVSDByNetPartition returns the VSD of the net partition, or nil when none or
+// several VSDs of the config use it
+func GetVSDByNetPartition(config *Config, netPartition string) *VSD {
+	var found *VSD
+	for i := 0; i < len(config.Vsds); i++ {
+		if config.Vsds[i].NetPartition == netPartition {
+			if found != nil {
+				return nil
+			}
+			found = &config.Vsds[i]
+		}
+	}
+
+	return found
+}
+
+// GetConcurrency returns the maximum number of concurrent requests sent to one VSD
+func GetConcurrency(config *Config) int {
+	if config.Concurrency <= 0 {
+		return defaultConcurrency
+	}
+	return config.Concurrency
+}
+
+// GetServeConfig returns the serve section of the config with the defaults of the unset keys
+func GetServeConfig(config *Config) (ServeConfig, time.Duration, error) {
+	serveConfig := config.Serve
+	if serveConfig.Listen == "" {
+		serveConfig.Listen = defaultServeListen
+	}
+	if serveConfig.Interval == "" {
+		serveConfig.Interval = defaultServeInterval
+	}
+	if len(serveConfig.ResourceTypes) <= 0 {
+		serveConfig.ResourceTypes = []string{"all"}
+	}
+	if serveConfig.History <= 0 {
+		serveConfig.History = defaultServeHistory
+	}
+
+	interval, err := time.ParseDuration(serveConfig.Interval)
+	if err != nil || interval <= 0 {
+		return serveConfig, 0, fmt.Errorf("invalid serve interval %s", serveConfig.Interval)
+	}
+	return serveConfig, interval, nil
+}
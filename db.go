@@ -20,28 +20,37 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"strings"
 )
 
 var DB *sqlx.DB
 
+// NeutronSnapshot holds the rows of every table the scanners read, when it is
+// set the Select functions read it instead of the database
+var neutronSnapshot *NeutronSnapshot
+
 type Network struct {
 	ID                    string         `db:"id"`
+	ProjectID             sql.NullString `db:"project_id"`
 	AvailabilityZoneHints sql.NullString `db:"availability_zone_hints"`
 }
 
 type Subnet struct {
-	ID        string `db:"id"`
-	NetworkID string `db:"network_id"`
+	ID        string         `db:"id"`
+	ProjectID sql.NullString `db:"project_id"`
+	NetworkID string         `db:"network_id"`
 }
 
 type NuageSubnetL2domMapping struct {
 	SubnetID          string         `db:"subnet_id"`
 	NuageSubnetID     string         `db:"nuage_subnet_id"`
 	NuageL2domTmpltID sql.NullString `db:"nuage_l2dom_tmplt_id"`
+	NetPartitionName  sql.NullString `db:"net_partition_name"`
 }
 
 type Router struct {
-	ID string `db:"id"`
+	ID        string         `db:"id"`
+	ProjectID sql.NullString `db:"project_id"`
 }
 
 type RouterPort struct {
@@ -55,6 +64,33 @@ type NewarchAzRouterNuage struct {
 	NuageRouterID sql.NullString `db:"nuage_router_id"`
 }
 
+// selectFiltered runs the query, restricted to the rows whose column is one of
+// the IDs selected by the scan filter when it has some
+func selectFiltered(dest interface{}, query string, column string, args ...interface{}) error {
+	if scanFilter != nil && scanFilter.ids != nil {
+		if len(scanFilter.ids) <= 0 {
+			return nil
+		}
+
+		// The condition of the query may have an or
+		if strings.Contains(query, " where ") {
+			query = strings.Replace(query, " where ", " where (", 1) + ") and " + column + " in (?)"
+		} else {
+			query += " where " + column + " in (?)"
+		}
+		args = append(args, scanFilter.idList())
+	}
+	if len(args) <= 0 {
+		return DB.Select(dest, query)
+	}
+
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return err
+	}
+	return DB.Select(dest, query, args...)
+}
+
 func OpenDB(username string, password string, ipAddr string, port uint16, dbName string) error {
 	dsName := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8", username, password, ipAddr, port, dbName)
 	db, err := sqlx.Open("mysql", dsName)
@@ -62,30 +98,258 @@ func OpenDB(username string, password string, ipAddr string, port uint16, dbName
 		return err
 	}
 
+	if DB != nil {
+		DB.Close()
+	}
 	DB = db
 	return nil
 }
 
 func SelectAllNetworks(networks *[]Network) error {
-	return DB.Select(networks, "select id, availability_zone_hints from networks")
+	if neutronSnapshot != nil {
+		*networks = append(*networks, neutronSnapshot.Networks...)
+		return nil
+	}
+	return DB.Select(networks, "select id, project_id, availability_zone_hints from networks")
 }
 
 func SelectAllSubnets(subnets *[]Subnet) error {
-	return DB.Select(subnets, "select id, network_id from subnets")
+	if neutronSnapshot != nil {
+		appendFilteredRows(subnets, neutronSnapshot.Subnets, "id")
+		return nil
+	}
+	return selectFiltered(subnets, "select id, project_id, network_id from subnets", "id")
 }
 
 func SelectAllNuageSubnetL2domMappings(l2domMappings *[]NuageSubnetL2domMapping) error {
-	return DB.Select(l2domMappings, "select subnet_id, nuage_subnet_id, nuage_l2dom_tmplt_id from nuage_subnet_l2dom_mapping")
+	if neutronSnapshot != nil {
+		appendFilteredRows(l2domMappings, neutronSnapshot.NuageSubnetL2domMappings, "subnet_id")
+		return nil
+	}
+	return selectFiltered(l2domMappings, "select m.subnet_id, m.nuage_subnet_id, m.nuage_l2dom_tmplt_id, np.name as net_partition_name "+
+		"from nuage_subnet_l2dom_mapping m left join nuage_net_partitions np on np.id = m.net_partition_id", "m.subnet_id")
 }
 
 func SelectAllRouters(routers *[]Router) error {
-	return DB.Select(routers, "select id from routers")
+	if neutronSnapshot != nil {
+		appendFilteredRows(routers, neutronSnapshot.Routers, "id")
+		return nil
+	}
+	return selectFiltered(routers, "select id, project_id from routers", "id")
+}
+
+// SelectAllRouterPorts skips the ports of the routers which are only in AZs the
+// scan filter does not select
+func SelectAllRouterPorts(routerPorts *[]RouterPort) error {
+	if neutronSnapshot != nil {
+		otherAzRouterIDs := scanFilter.otherAzRouterIDs(neutronSnapshot.NewarchAzRouterNuages)
+		var rows []RouterPort
+		for _, routerPort := range neutronSnapshot.RouterPorts {
+			if !otherAzRouterIDs[routerPort.RouterID] {
+				rows = append(rows, routerPort)
+			}
+		}
+		appendFilteredRows(routerPorts, rows, "router_id")
+		return nil
+	}
+	query := "select router_id, port_id from routerports"
+	var args []interface{}
+	if azs := scanFilter.azList(); len(azs) > 0 {
+		query += " where router_id not in (select router_id from newarch_az_router_nuage where router_id is not null and az_name not in (?))" +
+			" or router_id in (select router_id from newarch_az_router_nuage where az_name is null or az_name in (?))"
+		args = append(args, azs, azs)
+	}
+	return selectFiltered(routerPorts, query, "router_id", args...)
+}
+
+func SelectAllNewarchAzRouterNuages(newarchAzRouterNuages *[]NewarchAzRouterNuage) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(newarchAzRouterNuages, neutronSnapshot.NewarchAzRouterNuages, "router_id")
+		return nil
+	}
+	return selectFiltered(newarchAzRouterNuages, "select router_id, az_name, nuage_router_id from newarch_az_router_nuage", "router_id")
+}
+
+type Port struct {
+	ID          string         `db:"id"`
+	ProjectID   sql.NullString `db:"project_id"`
+	NetworkID   string         `db:"network_id"`
+	DeviceID    string         `db:"device_id"`
+	DeviceOwner string         `db:"device_owner"`
+	Host        sql.NullString `db:"host"`
+	VnicType    sql.NullString `db:"vnic_type"`
+	VifType     sql.NullString `db:"vif_type"`
+}
+
+type IPAllocation struct {
+	PortID   string `db:"port_id"`
+	SubnetID string `db:"subnet_id"`
 }
 
-func SelectRouterPortsByRouterID(routerPorts *[]RouterPort, routerID string) error {
-	return DB.Select(routerPorts, "select router_id, port_id from routerports where router_id=?", routerID)
+func SelectAllPorts(ports *[]Port) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(ports, neutronSnapshot.Ports, "id")
+		return nil
+	}
+	return selectFiltered(ports, "select p.id, p.project_id, p.network_id, p.device_id, p.device_owner, b.host, b.vnic_type, b.vif_type "+
+		"from ports p left join ml2_port_bindings b on b.port_id = p.id", "p.id")
 }
 
-func SelectNewarchAzRouterNuagesByRouterID(newarchAzRouterNuage *[]NewarchAzRouterNuage, routerID string) error {
-	return DB.Select(newarchAzRouterNuage, "select router_id, az_name, nuage_router_id from newarch_az_router_nuage where router_id=?", routerID)
+func SelectAllIPAllocations(ipAllocations *[]IPAllocation) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(ipAllocations, neutronSnapshot.IPAllocations, "port_id")
+		return nil
+	}
+	return selectFiltered(ipAllocations, "select port_id, subnet_id from ipallocations", "port_id")
+}
+
+type SecurityGroup struct {
+	ID        string         `db:"id"`
+	ProjectID sql.NullString `db:"project_id"`
+	Name      sql.NullString `db:"name"`
+}
+
+type SecurityGroupRule struct {
+	ID              string         `db:"id"`
+	SecurityGroupID string         `db:"security_group_id"`
+	Direction       sql.NullString `db:"direction"`
+}
+
+type SecurityGroupPortBinding struct {
+	PortID          string `db:"port_id"`
+	SecurityGroupID string `db:"security_group_id"`
+}
+
+func SelectAllSecurityGroups(securityGroups *[]SecurityGroup) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(securityGroups, neutronSnapshot.SecurityGroups, "id")
+		return nil
+	}
+	return selectFiltered(securityGroups, "select id, project_id, name from securitygroups", "id")
+}
+
+func SelectAllSecurityGroupRules(securityGroupRules *[]SecurityGroupRule) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(securityGroupRules, neutronSnapshot.SecurityGroupRules, "security_group_id")
+		return nil
+	}
+	return selectFiltered(securityGroupRules, "select id, security_group_id, direction from securitygrouprules", "security_group_id")
+}
+
+func SelectAllSecurityGroupPortBindings(securityGroupPortBindings *[]SecurityGroupPortBinding) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(securityGroupPortBindings, neutronSnapshot.SecurityGroupPortBindings, "security_group_id")
+		return nil
+	}
+	return selectFiltered(securityGroupPortBindings, "select port_id, security_group_id from securitygroupportbindings", "security_group_id")
+}
+
+type FloatingIP struct {
+	ID                string         `db:"id"`
+	ProjectID         sql.NullString `db:"project_id"`
+	FloatingIPAddress string         `db:"floating_ip_address"`
+	FloatingNetworkID string         `db:"floating_network_id"`
+	FixedPortID       sql.NullString `db:"fixed_port_id"`
+	RouterID          sql.NullString `db:"router_id"`
+}
+
+func SelectAllFloatingIPs(floatingIPs *[]FloatingIP) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(floatingIPs, neutronSnapshot.FloatingIPs, "id")
+		return nil
+	}
+	return selectFiltered(floatingIPs, "select id, project_id, floating_ip_address, floating_network_id, fixed_port_id, router_id from floatingips", "id")
+}
+
+type RouterGateway struct {
+	ID         string         `db:"id"`
+	GwPortID   sql.NullString `db:"gw_port_id"`
+	EnableSnat bool           `db:"enable_snat"`
+}
+
+type NuageSubnetParameter struct {
+	SubnetID       string `db:"subnet_id"`
+	ParameterName  string `db:"parameter_name"`
+	ParameterValue string `db:"parameter_value"`
+}
+
+func SelectAllRouterGateways(routerGateways *[]RouterGateway) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(routerGateways, neutronSnapshot.RouterGateways, "id")
+		return nil
+	}
+	return selectFiltered(routerGateways, "select id, gw_port_id, enable_snat from routers", "id")
+}
+
+func SelectAllNuageSubnetParameters(subnetParameters *[]NuageSubnetParameter) error {
+	if neutronSnapshot != nil {
+		appendFilteredRows(subnetParameters, neutronSnapshot.NuageSubnetParameters, "subnet_id")
+		return nil
+	}
+	return selectFiltered(subnetParameters, "select subnet_id, parameter_name, parameter_value from nuage_subnet_parameter", "subnet_id")
+}
+
+func SelectNuageSubnetParametersByName(subnetParameters *[]NuageSubnetParameter, parameterName string) error {
+	if neutronSnapshot != nil {
+		var allSubnetParameters []NuageSubnetParameter
+		appendFilteredRows(&allSubnetParameters, neutronSnapshot.NuageSubnetParameters, "subnet_id")
+		for _, subnetParameter := range allSubnetParameters {
+			if subnetParameter.ParameterName == parameterName {
+				*subnetParameters = append(*subnetParameters, subnetParameter)
+			}
+		}
+		return nil
+	}
+	return selectFiltered(subnetParameters, "select subnet_id, parameter_name, parameter_value from nuage_subnet_parameter where parameter_name=?", "subnet_id", parameterName)
+}
+
+// SelectDanglingColumnValues returns the value of column for every row of table
+// which refers to a row of parentTable that no longer exists
+func SelectDanglingColumnValues(values *[]string, table string, column string, parentTable string) error {
+	query := fmt.Sprintf("select %[2]s from %[1]s where %[2]s is not null and %[2]s not in (select id from %[3]s)", table, column, parentTable)
+	return DB.Select(values, query)
+}
+
+type NeutronSnapshot struct {
+	Networks                  []Network                  `json:"networks"`
+	Subnets                   []Subnet                   `json:"subnets"`
+	NuageSubnetL2domMappings  []NuageSubnetL2domMapping  `json:"nuage_subnet_l2dom_mappings"`
+	Routers                   []Router                   `json:"routers"`
+	RouterPorts               []RouterPort               `json:"router_ports"`
+	NewarchAzRouterNuages     []NewarchAzRouterNuage     `json:"newarch_az_router_nuages"`
+	Ports                     []Port                     `json:"ports"`
+	IPAllocations             []IPAllocation             `json:"ip_allocations"`
+	SecurityGroups            []SecurityGroup            `json:"security_groups"`
+	SecurityGroupRules        []SecurityGroupRule        `json:"security_group_rules"`
+	SecurityGroupPortBindings []SecurityGroupPortBinding `json:"security_group_port_bindings"`
+	FloatingIPs               []FloatingIP               `json:"floating_ips"`
+	RouterGateways            []RouterGateway            `json:"router_gateways"`
+	NuageSubnetParameters     []NuageSubnetParameter     `json:"nuage_subnet_parameters"`
+}
+
+// SelectNeutronSnapshot reads every table the scanners need from the database
+func SelectNeutronSnapshot(snapshot *NeutronSnapshot) error {
+	selects := []func() error{
+		func() error { return SelectAllNetworks(&snapshot.Networks) },
+		func() error { return SelectAllSubnets(&snapshot.Subnets) },
+		func() error { return SelectAllNuageSubnetL2domMappings(&snapshot.NuageSubnetL2domMappings) },
+		func() error { return SelectAllRouters(&snapshot.Routers) },
+		func() error { return SelectAllRouterPorts(&snapshot.RouterPorts) },
+		func() error { return SelectAllNewarchAzRouterNuages(&snapshot.NewarchAzRouterNuages) },
+		func() error { return SelectAllPorts(&snapshot.Ports) },
+		func() error { return SelectAllIPAllocations(&snapshot.IPAllocations) },
+		func() error { return SelectAllSecurityGroups(&snapshot.SecurityGroups) },
+		func() error { return SelectAllSecurityGroupRules(&snapshot.SecurityGroupRules) },
+		func() error { return SelectAllSecurityGroupPortBindings(&snapshot.SecurityGroupPortBindings) },
+		func() error { return SelectAllFloatingIPs(&snapshot.FloatingIPs) },
+		func() error { return SelectAllRouterGateways(&snapshot.RouterGateways) },
+		func() error { return SelectAllNuageSubnetParameters(&snapshot.NuageSubnetParameters) },
+	}
+	for _, selectFunc := range selects {
+		err := selectFunc()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,206 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"time"
+)
+
+// Status of a finding between two reports
+const (
+	DiffStatusAdded     string = "added"
+	DiffStatusRemoved   string = "removed"
+	DiffStatusUnchanged string = "unchanged"
+	DiffStatusSkipped   string = "skipped"
+)
+
+// DiffFinding is a finding of a report diff, Age is how long it has persisted
+// since it was first seen, until the old report for the removed ones
+type DiffFinding struct {
+	Finding
+	Status     string `json:"status"`
+	AgeSeconds int64  `json:"age_seconds"`
+}
+
+// ReportDiff is what changed between two reports, matching findings by fingerprint
+type ReportDiff struct {
+	Version      string        `json:"version"`
+	OldReport    string        `json:"old_report"`
+	NewReport    string        `json:"new_report"`
+	OldStartTime time.Time     `json:"old_start_time"`
+	NewStartTime time.Time     `json:"new_start_time"`
+	Added        []DiffFinding `json:"added"`
+	Removed      []DiffFinding `json:"removed"`
+	Unchanged    []DiffFinding `json:"unchanged"`
+
+	// Resource types which were not compared, with the reason
+	Skipped map[string]string `json:"skipped"`
+}
+
+func newDiffFinding(finding Finding, status string, firstSeen time.Time, seen time.Time) DiffFinding {
+	if finding.FirstSeen != nil {
+		firstSeen = *finding.FirstSeen
+	}
+	finding.FirstSeen = &firstSeen
+	return DiffFinding{Finding: finding, Status: status, AgeSeconds: int64(seen.Sub(firstSeen) / time.Second)}
+}
+
+// Age returns the age of the finding rounded to the nearest hour, e.g. 3d4h
+func (f DiffFinding) Age() string {
+	hours := (f.AgeSeconds + 1800) / 3600
+	if hours < 24 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dd%dh", hours/24, hours%24)
+}
+
+// SkippedResourceTypes returns the resource types which were not compared, sorted
+func (d *ReportDiff) SkippedResourceTypes() []string {
+	var resourceTypes []string
+	for resourceType := range d.Skipped {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+	return resourceTypes
+}
+
+// diffSkipReason returns why the resource type cannot be compared, or an empty
+// string if it was scanned without error in both reports
+func diffSkipReason(oldReport *Report, newReport *Report, resourceType string) string {
+	if oldReport.Errors[resourceType] != "" {
+		return "failed in the old report: " + oldReport.Errors[resourceType]
+	}
+	if newReport.Errors[resourceType] != "" {
+		return "failed in the new report: " + newReport.Errors[resourceType]
+	}
+	if _, ok := oldReport.Findings[resourceType]; !ok {
+		return "not scanned in the old report"
+	}
+	if _, ok := newReport.Findings[resourceType]; !ok {
+		return "not scanned in the new report"
+	}
+	return ""
+}
+
+// DiffReports compares the resource types scanned without error in both reports,
+// the resource types of either report which were not are listed as skipped
+func DiffReports(oldReport *Report, newReport *Report) *ReportDiff {
+	diff := &ReportDiff{
+		Version:      Version(),
+		OldStartTime: oldReport.StartTime,
+		NewStartTime: newReport.StartTime,
+		Added:        []DiffFinding{},
+		Removed:      []DiffFinding{},
+		Unchanged:    []DiffFinding{},
+		Skipped:      make(map[string]string),
+	}
+
+	resourceTypeMap := make(map[string]bool)
+	for _, report := range []*Report{oldReport, newReport} {
+		for resourceType := range report.Findings {
+			resourceTypeMap[resourceType] = true
+		}
+		for resourceType := range report.Errors {
+			resourceTypeMap[resourceType] = true
+		}
+	}
+	var resourceTypes []string
+	for resourceType := range resourceTypeMap {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+
+	for _, resourceType := range resourceTypes {
+		if reason := diffSkipReason(oldReport, newReport, resourceType); reason != "" {
+			logrus.WithField("func", "DiffReports").
+				Warningf("skip resource type %s which was %s", resourceType, reason)
+			diff.Skipped[resourceType] = reason
+			continue
+		}
+		oldFindings := oldReport.Findings[resourceType]
+		newFindings := newReport.Findings[resourceType]
+
+		oldFindingMap := make(map[string]*Finding)
+		for i := 0; i < len(oldFindings); i++ {
+			oldFindingMap[oldFindings[i].Fingerprint] = &oldFindings[i]
+		}
+		newFindingMap := make(map[string]*Finding)
+		for i := 0; i < len(newFindings); i++ {
+			newFindingMap[newFindings[i].Fingerprint] = &newFindings[i]
+		}
+
+		for _, newFinding := range newFindings {
+			oldFinding := oldFindingMap[newFinding.Fingerprint]
+			if oldFinding == nil {
+				diff.Added = append(diff.Added, newDiffFinding(newFinding, DiffStatusAdded, newReport.StartTime, newReport.StartTime))
+				continue
+			}
+			firstSeen := oldReport.StartTime
+			if oldFinding.FirstSeen != nil {
+				firstSeen = *oldFinding.FirstSeen
+			}
+			if newFinding.FirstSeen != nil && newFinding.FirstSeen.Before(firstSeen) {
+				firstSeen = *newFinding.FirstSeen
+			}
+			newFinding.FirstSeen = nil
+			diff.Unchanged = append(diff.Unchanged, newDiffFinding(newFinding, DiffStatusUnchanged, firstSeen, newReport.StartTime))
+		}
+
+		for _, oldFinding := range oldFindings {
+			if newFindingMap[oldFinding.Fingerprint] == nil {
+				diff.Removed = append(diff.Removed, newDiffFinding(oldFinding, DiffStatusRemoved, oldReport.StartTime, oldReport.StartTime))
+			}
+		}
+	}
+
+	return diff
+}
+
+// startDiff writes what changed between two reports written in JSON format
+func startDiff(oldPath string, newPath string, options *JobOptions) error {
+	oldReport, err := ReadReport(oldPath)
+	if err != nil {
+		return err
+	}
+	newReport, err := ReadReport(newPath)
+	if err != nil {
+		return err
+	}
+
+	diff := DiffReports(oldReport, newReport)
+	diff.OldReport = oldPath
+	diff.NewReport = newPath
+
+	writer := reportWriters[options.Format]
+	if options.Output == "" || options.Output == "-" {
+		return writer.WriteDiff(os.Stdout, diff)
+	}
+
+	file, err := os.Create(options.Output)
+	if err != nil {
+		return err
+	}
+	err = writer.WriteDiff(file, diff)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
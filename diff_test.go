@@ -0,0 +1,74 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffReportsSkipsResourceTypesOfOneSide(t *testing.T) {
+	startTime := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	oldReport := &Report{
+		StartTime: startTime,
+		Findings: map[string][]Finding{
+			ResTypeSubnet: {{Fingerprint: "a"}, {Fingerprint: "b"}},
+			ResTypeRouter: {{Fingerprint: "r"}},
+			ResTypePort:   {},
+		},
+		Errors: map[string]string{ResTypeDummyfip: "timeout"},
+	}
+	newReport := &Report{
+		StartTime: startTime.Add(time.Hour),
+		Findings: map[string][]Finding{
+			ResTypeSubnet:        {{Fingerprint: "b"}, {Fingerprint: "c"}},
+			ResTypeDummyfip:      {},
+			ResTypeSecuritygroup: {{Fingerprint: "s"}},
+		},
+		Errors: map[string]string{ResTypePort: "refused"},
+	}
+
+	diff := DiffReports(oldReport, newReport)
+	expected := map[string]string{
+		ResTypeRouter:        "not scanned in the new report",
+		ResTypePort:          "failed in the new report: refused",
+		ResTypeDummyfip:      "failed in the old report: timeout",
+		ResTypeSecuritygroup: "not scanned in the old report",
+	}
+	if !reflect.DeepEqual(diff.Skipped, expected) {
+		t.Errorf("skipped %q, expected %q", diff.Skipped, expected)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].Fingerprint != "c" || len(diff.Removed) != 1 || diff.Removed[0].Fingerprint != "a" ||
+		len(diff.Unchanged) != 1 || diff.Unchanged[0].Fingerprint != "b" {
+		t.Errorf("subnet findings were not compared: %+v", diff)
+	}
+}
+
+func TestDiffFindingAge(t *testing.T) {
+	for ageSeconds, expected := range map[int64]string{
+		0:                       "0h",
+		29 * 60:                 "0h",
+		30 * 60:                 "1h",
+		23*3600 + 40*60:         "1d0h",
+		3*86400 + 4*3600:        "3d4h",
+		3*86400 + 4*3600 + 2000: "3d5h",
+	} {
+		if age := (DiffFinding{AgeSeconds: ageSeconds}).Age(); age != expected {
+			t.Errorf("age of %d seconds is %s, expected %s", ageSeconds, age, expected)
+		}
+	}
+}
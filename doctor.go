@@ -0,0 +1,139 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Tables of the Neutron database read by the scanners and cleanup
+var doctorTables = []string{
+	"networks", "subnets", "nuage_subnet_l2dom_mapping", "nuage_net_partitions", "nuage_subnet_parameter",
+	"routers", "routerports", "newarch_az_router_nuage", "ports", "ml2_port_bindings",
+	"ipallocations", "securitygroups", "securitygrouprules", "securitygroupportbindings",
+	"floatingips",
+}
+
+// checkConfig returns the problems of the config which would make the scanners fail
+// or report wrong findings
+func checkConfig(config *Config) []string {
+	var problems []string
+	if len(config.Vsds) <= 0 {
+		problems = append(problems, "no vsd is configured")
+	}
+	azs := make(map[string]bool)
+	cmsIDs := make(map[string]bool)
+	for i, vsd := range config.Vsds {
+		for _, key := range [][2]string{
+			{"url", vsd.URL}, {"organization", vsd.Organization}, {"net_partition", vsd.NetPartition},
+			{"cms_id", vsd.CMSID}, {"az", vsd.AZ},
+		} {
+			if key[1] == "" {
+				problems = append(problems, fmt.Sprintf("vsd %d has no %s", i+1, key[0]))
+			}
+		}
+		if vsd.AZ != "" && azs[vsd.AZ] {
+			problems = append(problems, fmt.Sprintf("az %s is configured twice", vsd.AZ))
+		}
+		azs[vsd.AZ] = true
+		if vsd.CMSID != "" && cmsIDs[vsd.CMSID] {
+			problems = append(problems, fmt.Sprintf("cms_id %s is configured twice", vsd.CMSID))
+		}
+		cmsIDs[vsd.CMSID] = true
+	}
+	_, _, err := GetServeConfig(config)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// checkDB checks the connection to the Neutron database and that every table can be read
+func checkDB(config *Config) error {
+	neu := config.Neu
+	err := OpenDB(neu.Username, neu.Password, neu.IPAddr, neu.Port, neu.DBName)
+	if err != nil {
+		return err
+	}
+	err = DB.Ping()
+	if err != nil {
+		return err
+	}
+
+	var missingTables []string
+	for _, table := range doctorTables {
+		var count []int
+		err = DB.Select(&count, fmt.Sprintf("select 1 from %s limit 1", table))
+		if err != nil {
+			missingTables = append(missingTables, table)
+		}
+	}
+	if len(missingTables) > 0 {
+		return fmt.Errorf("cannot read tables %s", strings.Join(missingTables, ", "))
+	}
+	return nil
+}
+
+// startDoctor checks the config, the Neutron database and the VSDs, writes one line
+// per check to w and returns whether all checks passed
+func startDoctor(configPath string, w io.Writer) bool {
+	report := func(ok bool, check string, detail string) {
+		status := "OK"
+		if !ok {
+			status = "FAIL"
+		}
+		if detail != "" {
+			check += ": " + detail
+		}
+		fmt.Fprintf(w, "%-4s  %s\n", status, check)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		report(false, "config "+configPath, err.Error())
+		return false
+	}
+	globalConfig = config
+
+	problems := checkConfig(config)
+	report(len(problems) <= 0, "config "+configPath, strings.Join(problems, ", "))
+	passed := len(problems) <= 0
+
+	check := fmt.Sprintf("neutron database %s:%d/%s", config.Neu.IPAddr, config.Neu.Port, config.Neu.DBName)
+	err = checkDB(config)
+	if err != nil {
+		report(false, check, err.Error())
+		passed = false
+	} else {
+		report(true, check, "")
+	}
+
+	resetVsdSessions()
+	for i := 0; i < len(config.Vsds); i++ {
+		vsd := &config.Vsds[i]
+		check := fmt.Sprintf("vsd %s of az %s, net partition %s", vsd.URL, vsd.AZ, vsd.NetPartition)
+		_, err := GetVsdSession(vsd)
+		if err != nil {
+			report(false, check, err.Error())
+			passed = false
+		} else {
+			report(true, check, "")
+		}
+	}
+	return passed
+}
@@ -15,5 +15,287 @@
 
 package main
 
-func scanResForDummyFip() {
+import (
+	"fmt"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Finding codes
+const (
+	CodeFloatingIpMissing            string = "floatingip-missing"
+	CodeFloatingIpOrphan             string = "floatingip-orphan"
+	CodeFloatingIpExternalIDEmpty    string = "floatingip-external-id-empty"
+	CodeFloatingIpExternalIDInvalid  string = "floatingip-external-id-invalid"
+	CodeFloatingIpVPortMissing       string = "floatingip-vport-missing"
+	CodeFloatingIpVPortMismatch      string = "floatingip-vport-mismatch"
+	CodeFloatingIpVPortUnexpected    string = "floatingip-vport-unexpected"
+	CodeSharedNetworkResourceMissing string = "shared-network-resource-missing"
+)
+
+// Neutron resources
+var neutronFloatingIPs []FloatingIP
+var neutronFloatingIPMap map[string]*FloatingIP
+
+// Nuage resources
+var nuageFloatingIps vspk.FloatingIpsList
+var nuageSharedNetworkResources vspk.SharedNetworkResourcesList
+var nuageFloatingIpMap map[string]*vspk.FloatingIp
+var nuageFloatingIpVsdMap map[string]*VSD
+var nuageSharedNetworkResourceMap map[string]*vspk.SharedNetworkResource
+var nuageFloatingIpVPortMap map[string]*vspk.VPort
+
+func dumpAllNeutronFloatingIPResources() error {
+	neutronFloatingIPs = nil
+
+	logrus.WithField("func", "dumpAllNeutronFloatingIPResources").
+		Info("SelectAllFloatingIPs")
+	err := SelectAllFloatingIPs(&neutronFloatingIPs)
+	if err != nil {
+		return err
+	}
+	neutronFloatingIPMap = make(map[string]*FloatingIP)
+	for i := 0; i < len(neutronFloatingIPs); i++ {
+		floatingIP := &neutronFloatingIPs[i]
+		neutronFloatingIPMap[floatingIP.ID] = floatingIP
+	}
+
+	// The AZs of the routers tell on which VSD the floating ips live
+	return dumpAllNeutronRouterResources()
+}
+
+func dumpAllNuageFloatingIpResources() error {
+	nuageFloatingIps = nil
+	nuageSharedNetworkResources = nil
+	nuageFloatingIpMap = make(map[string]*vspk.FloatingIp)
+	nuageFloatingIpVsdMap = make(map[string]*VSD)
+	nuageSharedNetworkResourceMap = make(map[string]*vspk.SharedNetworkResource)
+	nuageFloatingIpVPortMap = make(map[string]*vspk.VPort)
+
+	// Fetch the VSDs in parallel, the loop below only reads the inventories
+	err := prefetchVsdInventories(func(inventory *VsdInventory) error {
+		_, err := inventory.SharedNetworkResources()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.FloatingIps()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.VPorts()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(globalConfig.Vsds); i++ {
+		vsd := &globalConfig.Vsds[i]
+		inventory, err := GetVsdInventory(vsd)
+		if err != nil {
+			return err
+		}
+
+		sharedNetworkResources, err := inventory.SharedNetworkResources()
+		if err != nil {
+			return err
+		}
+		nuageSharedNetworkResources = append(nuageSharedNetworkResources, sharedNetworkResources...)
+
+		floatingIps, err := inventory.FloatingIps()
+		if err != nil {
+			return err
+		}
+		nuageFloatingIps = append(nuageFloatingIps, floatingIps...)
+		for _, floatingIp := range floatingIps {
+			nuageFloatingIpVsdMap[floatingIp.ID] = vsd
+		}
+
+		vports, err := inventory.VPorts()
+		if err != nil {
+			return err
+		}
+		for _, vport := range vports {
+			if vport.AssociatedFloatingIPID != "" {
+				nuageFloatingIpVPortMap[vport.AssociatedFloatingIPID] = vport
+			}
+		}
+	}
+
+	for _, floatingIp := range nuageFloatingIps {
+		if floatingIp.ExternalID == "" {
+			continue
+		}
+		if nuageFloatingIpMap[floatingIp.ExternalID] != nil {
+			logrus.WithFields(logrus.Fields{"func": "dumpAllNuageFloatingIpResources", "object": "nuage"}).
+				Warning("found redundant floating ip " + floatingIp.ExternalID)
+			continue
+		}
+		nuageFloatingIpMap[floatingIp.ExternalID] = floatingIp
+	}
+	for _, sharedNetworkResource := range nuageSharedNetworkResources {
+		nuageSharedNetworkResourceMap[sharedNetworkResource.ID] = sharedNetworkResource
+	}
+
+	return nil
+}
+
+// checkFloatingIpVPort checks that the floating ip is attached to the vport of the fixed port
+func checkFloatingIpVPort(nuageFloatingIp *vspk.FloatingIp, neutronFloatingIP *FloatingIP) *Finding {
+	finding := Finding{
+		ResourceType: ResTypeDummyfip,
+		Side:         SideNuage,
+		NeutronID:    neutronFloatingIP.ID,
+		VsdID:        nuageFloatingIp.ID,
+		ExternalID:   nuageFloatingIp.ExternalID,
+	}.withVsd(nuageFloatingIpVsdMap[nuageFloatingIp.ID])
+
+	nuageVPort := nuageFloatingIpVPortMap[nuageFloatingIp.ID]
+	if !neutronFloatingIP.FixedPortID.Valid {
+		if nuageVPort == nil {
+			return nil
+		}
+		finding.Code = CodeFloatingIpVPortUnexpected
+		finding.Message = fmt.Sprintf("floating ip %s is attached to vport %s but floatingips.fixed_port_id is null", nuageFloatingIp.ID, nuageVPort.ID)
+		return &finding
+	}
+
+	if nuageVPort == nil {
+		finding.Code = CodeFloatingIpVPortMissing
+		finding.Message = fmt.Sprintf("floating ip %s is not attached to the vport of port %s", nuageFloatingIp.ID, neutronFloatingIP.FixedPortID.String)
+		return &finding
+	}
+
+	neutronPortID := strings.Split(nuageVPort.ExternalID, "@")[0]
+	if neutronPortID != neutronFloatingIP.FixedPortID.String {
+		finding.Code = CodeFloatingIpVPortMismatch
+		finding.Message = fmt.Sprintf("floating ip %s is attached to vport %s of port %s instead of port %s",
+			nuageFloatingIp.ID, nuageVPort.ID, neutronPortID, neutronFloatingIP.FixedPortID.String)
+		return &finding
+	}
+
+	return nil
+}
+
+func scanResForDummyFipBaseOnNeutron() []Finding {
+	var findings []Finding
+	for i := 0; i < len(neutronFloatingIPs); i++ {
+		neutronFloatingIP := &neutronFloatingIPs[i]
+		// The floating ip is only created on VSD once it is associated
+		if !neutronFloatingIP.FixedPortID.Valid {
+			continue
+		}
+
+		// The floating ip is only looked for on the VSD of its router when it is known
+		var vsd *VSD
+		if neutronFloatingIP.RouterID.Valid {
+			vsd = routerVsd(neutronFloatingIP.RouterID.String)
+		}
+		if scanFilter.skipsVsd(vsd) {
+			continue
+		}
+		var nuageFloatingIp *vspk.FloatingIp
+		if vsd != nil {
+			nuageFloatingIp = nuageFloatingIpMap[neutronFloatingIP.ID+"@"+vsd.CMSID]
+			if nuageFloatingIp != nil && nuageFloatingIpVsdMap[nuageFloatingIp.ID] != vsd {
+				nuageFloatingIp = nil
+			}
+		} else {
+			for _, vsd := range globalConfig.Vsds {
+				nuageFloatingIp = nuageFloatingIpMap[neutronFloatingIP.ID+"@"+vsd.CMSID]
+				if nuageFloatingIp != nil {
+					break
+				}
+			}
+		}
+		if nuageFloatingIp == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeDummyfip,
+				Side:         SideNuage,
+				NeutronID:    neutronFloatingIP.ID,
+				Code:         CodeFloatingIpMissing,
+				Message:      fmt.Sprintf("floating ip of floatingips.id %s (%s) was not found", neutronFloatingIP.ID, neutronFloatingIP.FloatingIPAddress),
+			}.withVsd(vsd))
+		}
+	}
+
+	return findings
+}
+
+func scanResForDummyFipBaseOnNuage() []Finding {
+	var findings []Finding
+	for _, nuageFloatingIp := range nuageFloatingIps {
+		if nuageFloatingIp.AssociatedSharedNetworkResourceID != "" &&
+			nuageSharedNetworkResourceMap[nuageFloatingIp.AssociatedSharedNetworkResourceID] == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeDummyfip,
+				Side:         SideNuage,
+				VsdID:        nuageFloatingIp.ID,
+				ExternalID:   nuageFloatingIp.ExternalID,
+				Code:         CodeSharedNetworkResourceMissing,
+				Message: fmt.Sprintf("shared network resource %s of floating ip %s was not found",
+					nuageFloatingIp.AssociatedSharedNetworkResourceID, nuageFloatingIp.ID),
+			}.withVsd(nuageFloatingIpVsdMap[nuageFloatingIp.ID]))
+		}
+
+		if nuageFloatingIp.ExternalID == "" {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeDummyfip,
+				Side:         SideNuage,
+				VsdID:        nuageFloatingIp.ID,
+				Code:         CodeFloatingIpExternalIDEmpty,
+				Message:      fmt.Sprintf("found floating ip %s with empty externalID", nuageFloatingIp.ID),
+			}.withVsd(nuageFloatingIpVsdMap[nuageFloatingIp.ID]))
+			continue
+		}
+		neutronFloatingIPID := strings.Split(nuageFloatingIp.ExternalID, "@")[0]
+		if neutronFloatingIPID == "" {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeDummyfip,
+				Side:         SideNuage,
+				VsdID:        nuageFloatingIp.ID,
+				ExternalID:   nuageFloatingIp.ExternalID,
+				Code:         CodeFloatingIpExternalIDInvalid,
+				Message:      "invalid floating ip externalID " + nuageFloatingIp.ExternalID,
+			}.withVsd(nuageFloatingIpVsdMap[nuageFloatingIp.ID]))
+			continue
+		}
+		neutronFloatingIP := neutronFloatingIPMap[neutronFloatingIPID]
+		if neutronFloatingIP == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeDummyfip,
+				Side:         SideNeutron,
+				NeutronID:    neutronFloatingIPID,
+				VsdID:        nuageFloatingIp.ID,
+				ExternalID:   nuageFloatingIp.ExternalID,
+				Code:         CodeFloatingIpOrphan,
+				Message:      fmt.Sprintf("floatingips.id %s of floating ip %s (%s) was not found", neutronFloatingIPID, nuageFloatingIp.ID, nuageFloatingIp.Address),
+			}.withVsd(nuageFloatingIpVsdMap[nuageFloatingIp.ID]))
+			continue
+		}
+
+		finding := checkFloatingIpVPort(nuageFloatingIp, neutronFloatingIP)
+		if finding != nil {
+			findings = append(findings, *finding)
+		}
+	}
+
+	return findings
+}
+
+func scanResForDummyFip() ([]Finding, error) {
+	err := dumpAllNeutronFloatingIPResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNeutronFloatingIPResources: %s", err)
+	}
+
+	err = dumpAllNuageFloatingIpResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNuageFloatingIpResources: %s", err)
+	}
+
+	findings := scanResForDummyFipBaseOnNeutron()
+	findings = append(findings, scanResForDummyFipBaseOnNuage()...)
+	return findings, nil
 }
@@ -0,0 +1,122 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"reflect"
+	"testing"
+)
+
+func TestScanResForDummyFip(t *testing.T) {
+	// fip1 is associated with port p1 behind router r1 of bj, fip2 is not associated
+	neutron := NeutronSnapshot{
+		Routers:               []Router{{ID: "r1"}},
+		NewarchAzRouterNuages: []NewarchAzRouterNuage{{RouterID: nullString("r1"), AzName: nullString("bj"), NuageRouterID: nullString("d1")}},
+		FloatingIPs: []FloatingIP{
+			{ID: "fip1", FixedPortID: nullString("p1"), RouterID: nullString("r1")},
+			{ID: "fip2", RouterID: nullString("r1")},
+		},
+	}
+	f1 := &vspk.FloatingIp{ID: "f1", ExternalID: "fip1@cms-bj", AssociatedSharedNetworkResourceID: "snr1"}
+	snr1 := &vspk.SharedNetworkResource{ID: "snr1"}
+
+	for _, test := range []struct {
+		name        string
+		floatingIps vspk.FloatingIpsList
+		vports      vspk.VPortsList
+		expected    []string
+	}{
+		{
+			name:        "in sync",
+			floatingIps: vspk.FloatingIpsList{f1},
+			vports:      vspk.VPortsList{{ID: "v1", ExternalID: "p1@cms-bj", AssociatedFloatingIPID: "f1"}},
+			expected:    []string{},
+		},
+		{
+			name:     "missing",
+			expected: []string{"floatingip-missing fip1  bj"},
+		},
+		{
+			name: "orphan",
+			floatingIps: vspk.FloatingIpsList{
+				f1,
+				{ID: "f9", ExternalID: "fip9@cms-bj"},
+				{ID: "f-empty"},
+				{ID: "f-invalid", ExternalID: "@cms-bj"},
+			},
+			vports: vspk.VPortsList{{ID: "v1", ExternalID: "p1@cms-bj", AssociatedFloatingIPID: "f1"}},
+			expected: []string{
+				"floatingip-external-id-empty  f-empty bj",
+				"floatingip-external-id-invalid  f-invalid bj",
+				"floatingip-orphan fip9 f9 bj",
+			},
+		},
+		{
+			name:        "not attached to a vport",
+			floatingIps: vspk.FloatingIpsList{f1},
+			expected:    []string{"floatingip-vport-missing fip1 f1 bj"},
+		},
+		{
+			name:        "attached to the vport of another port",
+			floatingIps: vspk.FloatingIpsList{f1},
+			vports: vspk.VPortsList{
+				{ID: "v1", ExternalID: "p1@cms-bj"},
+				{ID: "v2", ExternalID: "p2@cms-bj", AssociatedFloatingIPID: "f1"},
+			},
+			expected: []string{"floatingip-vport-mismatch fip1 f1 bj"},
+		},
+		{
+			name:        "attached without fixed port",
+			floatingIps: vspk.FloatingIpsList{f1, {ID: "f2", ExternalID: "fip2@cms-bj", AssociatedSharedNetworkResourceID: "snr1"}},
+			vports: vspk.VPortsList{
+				{ID: "v1", ExternalID: "p1@cms-bj", AssociatedFloatingIPID: "f1"},
+				{ID: "v2", ExternalID: "p2@cms-bj", AssociatedFloatingIPID: "f2"},
+			},
+			expected: []string{"floatingip-vport-unexpected fip2 f2 bj"},
+		},
+		{
+			name:        "shared network resource missing",
+			floatingIps: vspk.FloatingIpsList{{ID: "f1", ExternalID: "fip1@cms-bj", AssociatedSharedNetworkResourceID: "snr9"}},
+			vports:      vspk.VPortsList{{ID: "v1", ExternalID: "p1@cms-bj", AssociatedFloatingIPID: "f1"}},
+			expected:    []string{"shared-network-resource-missing  f1 bj"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			loadTestSnapshot(t, &Snapshot{
+				Config:  Config{Vsds: testVsds},
+				Neutron: neutron,
+				Vsds: []VsdSnapshot{
+					{
+						AZ:                     "bj",
+						FloatingIps:            test.floatingIps,
+						VPorts:                 test.vports,
+						SharedNetworkResources: vspk.SharedNetworkResourcesList{snr1},
+					},
+					{AZ: "cs"},
+				},
+			})
+
+			findings, err := scanResForDummyFip()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := findingKeys(findings); !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("findings %q, expected %q", keys, test.expected)
+			}
+		})
+	}
+}
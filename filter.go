@@ -0,0 +1,442 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Number of IDs above which the VSD objects are fetched without filter expression,
+// as the expression is sent in a header whose size is limited
+const maxVsdFilterIDs = 50
+
+// ScanFilter restricts a scan to the VSDs of some AZs, to the objects of one Neutron
+// project or to some Neutron IDs or VSD IDs. The project and the IDs are resolved to
+// the IDs of the related objects recorded in the Neutron database before the scan,
+// e.g. a security group brings its rules, its ports and their subnets.
+type ScanFilter struct {
+	AZs     []string `json:"azs,omitempty"`
+	Vsds    []string `json:"vsds,omitempty"`
+	Project string   `json:"project,omitempty"`
+	IDs     []string `json:"ids,omitempty"`
+
+	azs        map[string]bool
+	allVsds    []VSD
+	neutronIDs map[string]bool
+	vsdIDs     map[string]bool
+	ids        map[string]bool
+}
+
+// Filter of the running scan, nil when the scan is not filtered
+var scanFilter *ScanFilter
+
+// Tables whose rows have a project_id
+var projectTables = []string{"networks", "subnets", "ports", "routers", "floatingips", "securitygroups"}
+
+// Relations adding the IDs of the related objects to the filter, in this order, the
+// columns starting with nuage_ hold VSD IDs
+var scanFilterRelations = []struct {
+	table   string
+	match   []string
+	columns []string
+}{
+	{"nuage_subnet_l2dom_mapping", []string{"nuage_subnet_id", "nuage_l2dom_tmplt_id"}, []string{"subnet_id"}},
+	{"newarch_az_router_nuage", []string{"nuage_router_id"}, []string{"router_id"}},
+	{"floatingips", []string{"id"}, []string{"fixed_port_id", "router_id"}},
+	{"securitygrouprules", []string{"security_group_id"}, []string{"id"}},
+	{"securitygroupportbindings", []string{"security_group_id"}, []string{"port_id"}},
+	{"ipallocations", []string{"port_id"}, []string{"subnet_id"}},
+	{"nuage_subnet_l2dom_mapping", []string{"subnet_id"}, []string{"nuage_subnet_id", "nuage_l2dom_tmplt_id"}},
+	{"newarch_az_router_nuage", []string{"router_id"}, []string{"nuage_router_id"}},
+}
+
+// IsEmpty tells whether the filter selects everything
+func (f *ScanFilter) IsEmpty() bool {
+	return len(f.AZs) <= 0 && len(f.Vsds) <= 0 && f.Project == "" && len(f.IDs) <= 0
+}
+
+func (f *ScanFilter) String() string {
+	var parts []string
+	if len(f.AZs) > 0 {
+		parts = append(parts, "az "+strings.Join(f.AZs, ", "))
+	}
+	if len(f.Vsds) > 0 {
+		parts = append(parts, "vsd "+strings.Join(f.Vsds, ", "))
+	}
+	if f.Project != "" {
+		parts = append(parts, "project "+f.Project)
+	}
+	if len(f.IDs) > 0 {
+		parts = append(parts, "id "+strings.Join(f.IDs, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// isValidID tells whether the ID can be put in a VSD filter expression and in SQL
+func isValidID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// skipsVsd tells whether the VSD is left out of the scan by the filter, the Neutron
+// rows of its AZ are not checked
+func (f *ScanFilter) skipsVsd(vsd *VSD) bool {
+	return f != nil && f.azs != nil && vsd != nil && !f.azs[vsd.AZ]
+}
+
+// skipsAZ tells whether the AZ is one of the config which the filter left out
+func (f *ScanFilter) skipsAZ(az string) bool {
+	return f != nil && f.azs != nil && GetVSDByAZ(&Config{Vsds: f.allVsds}, az) != nil && !f.azs[az]
+}
+
+// lookupVsd returns the VSD found by find in the config before the scan filter
+// narrowed it, so that the rows of the AZs left out are known as such instead of
+// having an unknown VSD. A scanned VSD is returned as it is in globalConfig.
+func lookupVsd(find func(config *Config) *VSD) *VSD {
+	if scanFilter == nil || scanFilter.allVsds == nil {
+		return find(globalConfig)
+	}
+	vsd := find(&Config{Vsds: scanFilter.allVsds})
+	if vsd == nil {
+		return nil
+	}
+	if scannedVsd := GetVSDByAZ(globalConfig, vsd.AZ); scannedVsd != nil {
+		return scannedVsd
+	}
+	return vsd
+}
+
+// azList returns the AZs selected by the filter, or nothing when it selects all AZs
+func (f *ScanFilter) azList() []string {
+	if f == nil {
+		return nil
+	}
+	return sortedKeys(f.azs)
+}
+
+// otherAzRouterIDs returns the routers whose AZs are all known and not selected by
+// the filter, their rows concern VSDs which are not scanned
+func (f *ScanFilter) otherAzRouterIDs(newarchAzRouterNuages []NewarchAzRouterNuage) map[string]bool {
+	if f == nil || f.azs == nil {
+		return nil
+	}
+
+	otherAzRouterIDs := make(map[string]bool)
+	selectedRouterIDs := make(map[string]bool)
+	for _, newarchAzRouterNuage := range newarchAzRouterNuages {
+		if !newarchAzRouterNuage.RouterID.Valid {
+			continue
+		}
+		routerID := newarchAzRouterNuage.RouterID.String
+		if newarchAzRouterNuage.AzName.Valid && !f.azs[newarchAzRouterNuage.AzName.String] {
+			otherAzRouterIDs[routerID] = true
+		} else {
+			selectedRouterIDs[routerID] = true
+		}
+	}
+	for routerID := range selectedRouterIDs {
+		delete(otherAzRouterIDs, routerID)
+	}
+	return otherAzRouterIDs
+}
+
+// idList returns the Neutron and VSD IDs of the filter
+func (f *ScanFilter) idList() []string {
+	return sortedKeys(f.ids)
+}
+
+// resolve restricts the VSDs of the config to the AZs and VSDs of the filter, and
+// finds the IDs of the objects selected by the project and the IDs of the filter
+// in the Neutron database or snapshot
+func (f *ScanFilter) resolve(config *Config) error {
+	if f.Project != "" && len(f.IDs) > 0 {
+		return fmt.Errorf("a project and IDs cannot be selected together")
+	}
+
+	if len(f.AZs) > 0 || len(f.Vsds) > 0 {
+		f.azs = make(map[string]bool)
+		for _, az := range f.AZs {
+			if GetVSDByAZ(config, az) == nil {
+				return fmt.Errorf("AZ %s is not in the config", az)
+			}
+			f.azs[az] = true
+		}
+		for _, url := range f.Vsds {
+			found := false
+			for _, vsd := range config.Vsds {
+				if strings.TrimSuffix(vsd.URL, "/") == strings.TrimSuffix(url, "/") {
+					f.azs[vsd.AZ] = true
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("VSD %s is not in the config", url)
+			}
+		}
+
+		f.allVsds = config.Vsds
+		var vsds []VSD
+		for _, vsd := range config.Vsds {
+			if f.azs[vsd.AZ] {
+				vsds = append(vsds, vsd)
+			}
+		}
+		config.Vsds = vsds
+	}
+
+	if f.Project == "" && len(f.IDs) <= 0 {
+		return nil
+	}
+
+	f.neutronIDs = make(map[string]bool)
+	f.vsdIDs = make(map[string]bool)
+	f.ids = make(map[string]bool)
+	if f.Project != "" {
+		if !isValidID(f.Project) {
+			return fmt.Errorf("invalid project %s", f.Project)
+		}
+		for _, table := range projectTables {
+			ids, err := selectRelatedIDs(table, []string{"project_id"}, []string{f.Project}, "id")
+			if err != nil {
+				return err
+			}
+			f.add(ids, false)
+		}
+	}
+	for _, id := range f.IDs {
+		if !isValidID(id) {
+			return fmt.Errorf("invalid ID %s", id)
+		}
+	}
+	// An ID may be a Neutron ID or a VSD ID, e.g. of an orphan which Neutron does not know
+	f.add(f.IDs, false)
+	f.add(f.IDs, true)
+
+	for _, relation := range scanFilterRelations {
+		for _, column := range relation.columns {
+			ids, err := selectRelatedIDs(relation.table, relation.match, f.idList(), column)
+			if err != nil {
+				return err
+			}
+			f.add(ids, strings.HasPrefix(column, "nuage_"))
+		}
+	}
+
+	logrus.WithField("func", "ScanFilter.resolve").
+		Infof("Filter %s selects %d Neutron IDs and %d VSD IDs", f, len(f.neutronIDs), len(f.vsdIDs))
+	return nil
+}
+
+func (f *ScanFilter) add(ids []string, vsd bool) {
+	for _, id := range ids {
+		if vsd {
+			f.vsdIDs[id] = true
+		} else {
+			f.neutronIDs[id] = true
+		}
+		f.ids[id] = true
+	}
+}
+
+// selectRelatedIDs returns the values of column of the rows of table whose match
+// columns have one of the values, from the Neutron snapshot when it is loaded
+func selectRelatedIDs(table string, match []string, values []string, column string) ([]string, error) {
+	if len(values) <= 0 {
+		return nil, nil
+	}
+
+	if neutronSnapshot != nil {
+		valueSet := make(map[string]bool)
+		for _, value := range values {
+			valueSet[value] = true
+		}
+		tableRows, err := neutronSnapshot.rows(table)
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		rows := reflect.ValueOf(tableRows)
+		for i := 0; i < rows.Len(); i++ {
+			for _, matchColumn := range match {
+				if valueSet[rowColumn(rows.Index(i), matchColumn)] {
+					if id := rowColumn(rows.Index(i), column); id != "" {
+						ids = append(ids, id)
+					}
+					break
+				}
+			}
+		}
+		return ids, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, matchColumn := range match {
+		conditions = append(conditions, matchColumn+" in (?)")
+		args = append(args, values)
+	}
+	query, args, err := sqlx.In(fmt.Sprintf("select distinct %s from %s where %s", column, table, strings.Join(conditions, " or ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	var nullIDs []sql.NullString
+	err = DB.Select(&nullIDs, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, id := range nullIDs {
+		if id.Valid && id.String != "" {
+			ids = append(ids, id.String)
+		}
+	}
+	return ids, nil
+}
+
+// rows returns the rows of the table in the snapshot
+func (s *NeutronSnapshot) rows(table string) (interface{}, error) {
+	switch table {
+	case "networks":
+		return s.Networks, nil
+	case "subnets":
+		return s.Subnets, nil
+	case "ports":
+		return s.Ports, nil
+	case "routers":
+		return s.Routers, nil
+	case "floatingips":
+		return s.FloatingIPs, nil
+	case "securitygroups":
+		return s.SecurityGroups, nil
+	case "securitygrouprules":
+		return s.SecurityGroupRules, nil
+	case "securitygroupportbindings":
+		return s.SecurityGroupPortBindings, nil
+	case "ipallocations":
+		return s.IPAllocations, nil
+	case "nuage_subnet_l2dom_mapping":
+		return s.NuageSubnetL2domMappings, nil
+	case "newarch_az_router_nuage":
+		return s.NewarchAzRouterNuages, nil
+	}
+	return nil, fmt.Errorf("unknown snapshot table %s", table)
+}
+
+// rowColumn returns the value of the field of the row tagged with the column
+func rowColumn(row reflect.Value, column string) string {
+	for i := 0; i < row.NumField(); i++ {
+		if row.Type().Field(i).Tag.Get("db") != column {
+			continue
+		}
+		switch value := row.Field(i).Interface().(type) {
+		case string:
+			return value
+		case sql.NullString:
+			return value.String
+		}
+	}
+	return ""
+}
+
+// appendFilteredRows appends the rows of the snapshot whose column is one of the
+// IDs selected by the scan filter, or all rows when it has none, to dest
+func appendFilteredRows(dest interface{}, rows interface{}, column string) {
+	destValue := reflect.ValueOf(dest).Elem()
+	rowsValue := reflect.ValueOf(rows)
+	for i := 0; i < rowsValue.Len(); i++ {
+		row := rowsValue.Index(i)
+		if scanFilter == nil || scanFilter.ids == nil || scanFilter.ids[rowColumn(row, column)] {
+			destValue.Set(reflect.Append(destValue, row))
+		}
+	}
+}
+
+// vsdFilterExpression returns the VSD filter expression matching the objects of the
+// IDs of the filter on the VSD, or nothing when all objects have to be fetched
+func (f *ScanFilter) vsdFilterExpression(vsd *VSD) string {
+	if f == nil || f.ids == nil || len(f.ids) <= 0 {
+		return ""
+	}
+	if len(f.ids) > maxVsdFilterIDs {
+		logrus.WithField("func", "ScanFilter.vsdFilterExpression").
+			Infof("Fetch all objects of %s, the filter has more than %d IDs", vsd.AZ, maxVsdFilterIDs)
+		return ""
+	}
+
+	var terms []string
+	for _, id := range sortedKeys(f.neutronIDs) {
+		terms = append(terms, fmt.Sprintf("externalID == '%s@%s'", id, vsd.CMSID))
+	}
+	for _, id := range sortedKeys(f.vsdIDs) {
+		terms = append(terms, fmt.Sprintf("ID == '%s'", id))
+	}
+	return strings.Join(terms, " or ")
+}
+
+// match tells whether the finding concerns the objects selected by the filter. The
+// findings whose AZ is unknown, e.g. of a subnet without mapping, are kept when AZs
+// are selected, as they may concern one of them.
+func (f *ScanFilter) match(finding *Finding) bool {
+	if f.azs != nil && finding.AZ != "" && !f.azs[finding.AZ] {
+		return false
+	}
+	if f.ids == nil {
+		return true
+	}
+
+	neutronID := finding.NeutronID
+	if neutronID == "" && finding.ExternalID != "" {
+		neutronID = strings.Split(finding.ExternalID, "@")[0]
+	}
+	return f.neutronIDs[neutronID] || f.vsdIDs[finding.VsdID]
+}
+
+// filterFindings returns the findings which match the scan filter
+func filterFindings(findings []Finding) []Finding {
+	if scanFilter == nil {
+		return findings
+	}
+
+	var filtered []Finding
+	for i := 0; i < len(findings); i++ {
+		if scanFilter.match(&findings[i]) {
+			filtered = append(filtered, findings[i])
+		}
+	}
+	return filtered
+}
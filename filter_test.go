@@ -0,0 +1,158 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadFilteredTestSnapshot loads the snapshot and resolves the filter against it
+func loadFilteredTestSnapshot(t *testing.T, snapshot *Snapshot, filter ScanFilter) {
+	loadTestSnapshot(t, snapshot)
+	err := filter.resolve(globalConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanFilter = &filter
+}
+
+func TestScanFilterKeepsFindingsOfUnknownAZ(t *testing.T) {
+	loadFilteredTestSnapshot(t, &Snapshot{Config: Config{Vsds: testVsds}}, ScanFilter{AZs: []string{"bj"}})
+
+	findings := filterFindings([]Finding{
+		{Code: CodeL2DomainMissing, NeutronID: "s1", AZ: "bj"},
+		{Code: CodeL2DomainMissing, NeutronID: "s2", AZ: "cs"},
+		{Code: CodeSubnetMappingMissing, NeutronID: "s3"},
+		{Code: CodeRouterAzMissing, NeutronID: "r1"},
+	})
+	var neutronIDs []string
+	for _, finding := range findings {
+		neutronIDs = append(neutronIDs, finding.NeutronID)
+	}
+	if !reflect.DeepEqual(neutronIDs, []string{"s1", "s3", "r1"}) {
+		t.Errorf("filter kept the findings of %q", neutronIDs)
+	}
+}
+
+func TestSelectAllRouterPortsSkipsOtherAZs(t *testing.T) {
+	loadFilteredTestSnapshot(t, &Snapshot{
+		Config: Config{Vsds: testVsds},
+		Neutron: NeutronSnapshot{
+			RouterPorts: []RouterPort{
+				{RouterID: "r-bj", PortID: "p1"},
+				{RouterID: "r-cs", PortID: "p2"},
+				{RouterID: "r-both", PortID: "p3"},
+				{RouterID: "r-null", PortID: "p4"},
+				{RouterID: "r-none", PortID: "p5"},
+			},
+			NewarchAzRouterNuages: []NewarchAzRouterNuage{
+				{RouterID: nullString("r-bj"), AzName: nullString("bj")},
+				{RouterID: nullString("r-cs"), AzName: nullString("cs")},
+				{RouterID: nullString("r-both"), AzName: nullString("cs")},
+				{RouterID: nullString("r-both"), AzName: nullString("bj")},
+				{RouterID: nullString("r-null"), AzName: nullString("cs")},
+				{RouterID: nullString("r-null")},
+			},
+		},
+	}, ScanFilter{AZs: []string{"bj"}})
+
+	var routerPorts []RouterPort
+	err := SelectAllRouterPorts(&routerPorts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var portIDs []string
+	for _, routerPort := range routerPorts {
+		portIDs = append(portIDs, routerPort.PortID)
+	}
+	if !reflect.DeepEqual(portIDs, []string{"p1", "p3", "p4", "p5"}) {
+		t.Errorf("selected the router ports %q", portIDs)
+	}
+}
+
+func TestSnapshotRowsOfUnknownTable(t *testing.T) {
+	_, err := (&NeutronSnapshot{}).rows("agents")
+	if err == nil {
+		t.Error("rows of an unknown table did not fail")
+	}
+}
+
+func TestScanFilterSkipsResourcesOfOtherAZs(t *testing.T) {
+	loadFilteredTestSnapshot(t, &Snapshot{
+		Config: Config{Vsds: testVsds},
+		Neutron: NeutronSnapshot{
+			Subnets: []Subnet{{ID: "s-bj"}, {ID: "s-cs"}},
+			NuageSubnetL2domMappings: []NuageSubnetL2domMapping{
+				{SubnetID: "s-bj", NuageSubnetID: "l-bj", NetPartitionName: nullString("OpenStack_bj")},
+				{SubnetID: "s-cs", NuageSubnetID: "l-cs", NetPartitionName: nullString("OpenStack_cs")},
+			},
+			Routers:               []Router{{ID: "r-cs"}},
+			NewarchAzRouterNuages: []NewarchAzRouterNuage{{RouterID: nullString("r-cs"), AzName: nullString("cs"), NuageRouterID: nullString("d-cs")}},
+			Ports:                 []Port{{ID: "p-cs", DeviceOwner: "compute:cs", VifType: nullString("ovs"), VnicType: nullString("normal")}},
+			IPAllocations:         []IPAllocation{{PortID: "p-cs", SubnetID: "s-cs"}},
+			SecurityGroups:        []SecurityGroup{{ID: "sg-cs"}},
+			SecurityGroupPortBindings: []SecurityGroupPortBinding{
+				{PortID: "p-cs", SecurityGroupID: "sg-cs"},
+			},
+			FloatingIPs: []FloatingIP{{ID: "fip-cs", FixedPortID: nullString("p-cs"), RouterID: nullString("r-cs")}},
+		},
+		Vsds: []VsdSnapshot{{AZ: "bj"}, {AZ: "cs"}},
+	}, ScanFilter{AZs: []string{"bj"}})
+
+	var findings []Finding
+	for _, scan := range []func() ([]Finding, error){
+		scanResForSubnet, scanResForRouter, scanResForPort, scanResForSecurityGroup, scanResForDummyFip,
+	} {
+		scanFindings, err := scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		findings = append(findings, scanFindings...)
+	}
+
+	// The objects of cs are missing too, but cs is not scanned
+	expected := []string{"vsd-subnet-missing s-bj l-bj bj"}
+	if keys := findingKeys(findings); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("findings %q, expected %q", keys, expected)
+	}
+}
+
+func TestScanFilterSelectsVsdID(t *testing.T) {
+	loadFilteredTestSnapshot(t, &Snapshot{
+		Config: Config{Vsds: testVsds},
+		Vsds: []VsdSnapshot{
+			{AZ: "bj", Domains: vspk.DomainsList{{ID: "d1", ExternalID: "r1@cms-bj"}, {ID: "d2", ExternalID: "r2@cms-bj"}}},
+			{AZ: "cs"},
+		},
+	}, ScanFilter{IDs: []string{"d1"}})
+
+	expression := scanFilter.vsdFilterExpression(&globalConfig.Vsds[0])
+	if !strings.Contains(expression, "ID == 'd1'") {
+		t.Errorf("filter expression %q does not select d1", expression)
+	}
+
+	findings, err := scanResForRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"domain-orphan r1 d1 bj"}
+	if keys := findingKeys(filterFindings(findings)); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("findings %q, expected %q", keys, expected)
+	}
+}
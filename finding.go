@@ -0,0 +1,67 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// Side of a finding, i.e. where the object is missing or inconsistent
+const (
+	SideNeutron string = "neutron"
+	SideNuage   string = "nuage"
+)
+
+// Finding is one inconsistency between Neutron and VSD, its fingerprint
+// identifies the same inconsistency across runs
+type Finding struct {
+	ResourceType string     `json:"resource_type"`
+	Side         string     `json:"side"`
+	NeutronID    string     `json:"neutron_id,omitempty"`
+	VsdID        string     `json:"vsd_id,omitempty"`
+	ExternalID   string     `json:"external_id,omitempty"`
+	AZ           string     `json:"az,omitempty"`
+	VsdURL       string     `json:"vsd_url,omitempty"`
+	Code         string     `json:"code"`
+	Message      string     `json:"message"`
+	Fingerprint  string     `json:"fingerprint"`
+	FirstSeen    *time.Time `json:"first_seen,omitempty"`
+}
+
+// withVsd records on which VSD the object of the finding lives
+func (f Finding) withVsd(vsd *VSD) Finding {
+	if vsd != nil {
+		f.AZ = vsd.AZ
+		f.VsdURL = vsd.URL
+	}
+	return f
+}
+
+func (f Finding) fingerprint() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{f.ResourceType, f.Code, f.NeutronID, f.VsdID, f.ExternalID}, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func logFindings(findings []Finding) {
+	for _, finding := range findings {
+		logrus.WithFields(logrus.Fields{"resource": finding.ResourceType, "object": finding.Side, "code": finding.Code}).
+			Warning(finding.Message)
+	}
+}
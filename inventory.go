@@ -0,0 +1,518 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// VsdInventory caches the objects of one VSD for the whole run, so that
+// every object class is fetched at most once whatever the scanners need.
+// Children of domains are fetched by a pool of concurrency workers. An
+// inventory is not safe for concurrent use, but different VSDs can be
+// fetched in parallel. The filter expression of the scan filter restricts the
+// objects which carry the ID of a Neutron object, the domains, l2domains and
+// acl templates are always fetched whole as the other objects live in them.
+type VsdInventory struct {
+	session     *VsdSession
+	concurrency int
+	filter      string
+
+	l2DomainTemplates        vspk.L2DomainTemplatesList
+	l2Domains                vspk.L2DomainsList
+	domains                  vspk.DomainsList
+	subnets                  vspk.SubnetsList
+	vports                   vspk.VPortsList
+	vmInterfaces             vspk.VMInterfacesList
+	policyGroups             vspk.PolicyGroupsList
+	ingressACLTemplates      vspk.IngressACLTemplatesList
+	egressACLTemplates       vspk.EgressACLTemplatesList
+	ingressACLEntryTemplates vspk.IngressACLEntryTemplatesList
+	egressACLEntryTemplates  vspk.EgressACLEntryTemplatesList
+	sharedNetworkResources   vspk.SharedNetworkResourcesList
+	floatingIps              vspk.FloatingIpsList
+
+	fetched map[string]bool
+}
+
+// VSD inventories of the run, keyed by AZ
+var vsdInventories = make(map[string]*VsdInventory)
+var vsdInventoriesMutex sync.Mutex
+
+// runParallel calls fn for every index in [0, count) from at most limit goroutines
+// and returns the first error, no index is handed out once a call failed
+func runParallel(limit int, count int, fn func(i int) error) error {
+	if limit > count {
+		limit = count
+	}
+	if limit < 1 {
+		limit = 1
+	}
+
+	indexes := make(chan int)
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < limit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				err := fn(i)
+				if err != nil {
+					failOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < count; i++ {
+		select {
+		case <-failed:
+			break feed
+		default:
+		}
+		select {
+		case indexes <- i:
+		case <-failed:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return firstErr
+}
+
+func resetVsdInventories() {
+	vsdInventoriesMutex.Lock()
+	defer vsdInventoriesMutex.Unlock()
+
+	vsdInventories = make(map[string]*VsdInventory)
+}
+
+// prefetchVsdInventories runs fetchFunc on the inventories of all VSDs in parallel
+func prefetchVsdInventories(fetchFunc func(inventory *VsdInventory) error) error {
+	return runParallel(len(globalConfig.Vsds), len(globalConfig.Vsds), func(i int) error {
+		inventory, err := GetVsdInventory(&globalConfig.Vsds[i])
+		if err != nil {
+			return err
+		}
+		return fetchFunc(inventory)
+	})
+}
+
+// GetVsdInventory returns the inventory of the VSD, logging in on first use
+func GetVsdInventory(vsd *VSD) (*VsdInventory, error) {
+	vsdInventoriesMutex.Lock()
+	defer vsdInventoriesMutex.Unlock()
+
+	inventory := vsdInventories[vsd.AZ]
+	if inventory != nil {
+		return inventory, nil
+	}
+
+	session, err := GetVsdSession(vsd)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory = &VsdInventory{
+		session:     session,
+		concurrency: GetConcurrency(globalConfig),
+		filter:      scanFilter.vsdFilterExpression(vsd),
+		fetched:     make(map[string]bool),
+	}
+	vsdInventories[vsd.AZ] = inventory
+	return inventory, nil
+}
+
+// fetch runs fetchFunc the first time the object class is asked for
+func (inv *VsdInventory) fetch(class string, fetchFunc func() error) error {
+	if inv.fetched[class] {
+		return nil
+	}
+
+	logrus.WithField("func", "VsdInventory.fetch").
+		Infof("Fetch all %s from %s", class, inv.session.VSD.NetPartition)
+	err := fetchFunc()
+	if err != nil {
+		return err
+	}
+
+	inv.fetched[class] = true
+	return nil
+}
+
+func (inv *VsdInventory) L2DomainTemplates() (vspk.L2DomainTemplatesList, error) {
+	err := inv.fetch("l2domaintemplates", func() error {
+		l2DomainTemplates, err := inv.session.FetchAllL2DomainTemplates(inv.filter)
+		inv.l2DomainTemplates = l2DomainTemplates
+		return err
+	})
+	return inv.l2DomainTemplates, err
+}
+
+func (inv *VsdInventory) L2Domains() (vspk.L2DomainsList, error) {
+	err := inv.fetch("l2domains", func() error {
+		l2Domains, err := inv.session.FetchAllL2Domains()
+		inv.l2Domains = l2Domains
+		return err
+	})
+	return inv.l2Domains, err
+}
+
+func (inv *VsdInventory) Domains() (vspk.DomainsList, error) {
+	err := inv.fetch("domains", func() error {
+		domains, err := inv.session.FetchAllDomains()
+		inv.domains = domains
+		return err
+	})
+	return inv.domains, err
+}
+
+func (inv *VsdInventory) Subnets() (vspk.SubnetsList, error) {
+	err := inv.fetch("subnets", func() error {
+		inv.subnets = nil
+
+		domains, err := inv.Domains()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.SubnetsList, len(domains))
+		err = runParallel(inv.concurrency, len(domains), func(i int) error {
+			subnets, err := inv.session.FetchAllSubnets(domains[i], inv.filter)
+			results[i] = subnets
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, subnets := range results {
+			inv.subnets = append(inv.subnets, subnets...)
+		}
+		return nil
+	})
+	return inv.subnets, err
+}
+
+// VPorts returns the vports of all domains and l2domains
+func (inv *VsdInventory) VPorts() (vspk.VPortsList, error) {
+	err := inv.fetch("vports", func() error {
+		inv.vports = nil
+
+		domains, err := inv.Domains()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.VPortsList, len(domains))
+		err = runParallel(inv.concurrency, len(domains), func(i int) error {
+			vports, err := inv.session.FetchAllDomainVPorts(domains[i], inv.filter)
+			results[i] = vports
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, vports := range results {
+			inv.vports = append(inv.vports, vports...)
+		}
+
+		l2Domains, err := inv.L2Domains()
+		if err != nil {
+			return err
+		}
+		results = make([]vspk.VPortsList, len(l2Domains))
+		err = runParallel(inv.concurrency, len(l2Domains), func(i int) error {
+			vports, err := inv.session.FetchAllL2DomainVPorts(l2Domains[i], inv.filter)
+			results[i] = vports
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, vports := range results {
+			inv.vports = append(inv.vports, vports...)
+		}
+		return nil
+	})
+	return inv.vports, err
+}
+
+// VMInterfaces returns the vminterfaces of all domains and l2domains
+func (inv *VsdInventory) VMInterfaces() (vspk.VMInterfacesList, error) {
+	err := inv.fetch("vminterfaces", func() error {
+		inv.vmInterfaces = nil
+
+		domains, err := inv.Domains()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.VMInterfacesList, len(domains))
+		err = runParallel(inv.concurrency, len(domains), func(i int) error {
+			vmInterfaces, err := inv.session.FetchAllDomainVMInterfaces(domains[i], inv.filter)
+			results[i] = vmInterfaces
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, vmInterfaces := range results {
+			inv.vmInterfaces = append(inv.vmInterfaces, vmInterfaces...)
+		}
+
+		l2Domains, err := inv.L2Domains()
+		if err != nil {
+			return err
+		}
+		results = make([]vspk.VMInterfacesList, len(l2Domains))
+		err = runParallel(inv.concurrency, len(l2Domains), func(i int) error {
+			vmInterfaces, err := inv.session.FetchAllL2DomainVMInterfaces(l2Domains[i], inv.filter)
+			results[i] = vmInterfaces
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, vmInterfaces := range results {
+			inv.vmInterfaces = append(inv.vmInterfaces, vmInterfaces...)
+		}
+		return nil
+	})
+	return inv.vmInterfaces, err
+}
+
+// PolicyGroups returns the policy groups of all domains and l2domains
+func (inv *VsdInventory) PolicyGroups() (vspk.PolicyGroupsList, error) {
+	err := inv.fetch("policygroups", func() error {
+		inv.policyGroups = nil
+
+		domains, err := inv.Domains()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.PolicyGroupsList, len(domains))
+		err = runParallel(inv.concurrency, len(domains), func(i int) error {
+			policyGroups, err := inv.session.FetchAllDomainPolicyGroups(domains[i], inv.filter)
+			results[i] = policyGroups
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, policyGroups := range results {
+			inv.policyGroups = append(inv.policyGroups, policyGroups...)
+		}
+
+		l2Domains, err := inv.L2Domains()
+		if err != nil {
+			return err
+		}
+		results = make([]vspk.PolicyGroupsList, len(l2Domains))
+		err = runParallel(inv.concurrency, len(l2Domains), func(i int) error {
+			policyGroups, err := inv.session.FetchAllL2DomainPolicyGroups(l2Domains[i], inv.filter)
+			results[i] = policyGroups
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, policyGroups := range results {
+			inv.policyGroups = append(inv.policyGroups, policyGroups...)
+		}
+		return nil
+	})
+	return inv.policyGroups, err
+}
+
+// IngressACLTemplates returns the ingress acl templates of all domains and l2domains
+func (inv *VsdInventory) IngressACLTemplates() (vspk.IngressACLTemplatesList, error) {
+	err := inv.fetch("ingressacltemplates", func() error {
+		inv.ingressACLTemplates = nil
+
+		domains, err := inv.Domains()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.IngressACLTemplatesList, len(domains))
+		err = runParallel(inv.concurrency, len(domains), func(i int) error {
+			ingressACLTemplates, err := inv.session.FetchAllDomainIngressACLTemplates(domains[i])
+			results[i] = ingressACLTemplates
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, ingressACLTemplates := range results {
+			inv.ingressACLTemplates = append(inv.ingressACLTemplates, ingressACLTemplates...)
+		}
+
+		l2Domains, err := inv.L2Domains()
+		if err != nil {
+			return err
+		}
+		results = make([]vspk.IngressACLTemplatesList, len(l2Domains))
+		err = runParallel(inv.concurrency, len(l2Domains), func(i int) error {
+			ingressACLTemplates, err := inv.session.FetchAllL2DomainIngressACLTemplates(l2Domains[i])
+			results[i] = ingressACLTemplates
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, ingressACLTemplates := range results {
+			inv.ingressACLTemplates = append(inv.ingressACLTemplates, ingressACLTemplates...)
+		}
+		return nil
+	})
+	return inv.ingressACLTemplates, err
+}
+
+// EgressACLTemplates returns the egress acl templates of all domains and l2domains
+func (inv *VsdInventory) EgressACLTemplates() (vspk.EgressACLTemplatesList, error) {
+	err := inv.fetch("egressacltemplates", func() error {
+		inv.egressACLTemplates = nil
+
+		domains, err := inv.Domains()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.EgressACLTemplatesList, len(domains))
+		err = runParallel(inv.concurrency, len(domains), func(i int) error {
+			egressACLTemplates, err := inv.session.FetchAllDomainEgressACLTemplates(domains[i])
+			results[i] = egressACLTemplates
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, egressACLTemplates := range results {
+			inv.egressACLTemplates = append(inv.egressACLTemplates, egressACLTemplates...)
+		}
+
+		l2Domains, err := inv.L2Domains()
+		if err != nil {
+			return err
+		}
+		results = make([]vspk.EgressACLTemplatesList, len(l2Domains))
+		err = runParallel(inv.concurrency, len(l2Domains), func(i int) error {
+			egressACLTemplates, err := inv.session.FetchAllL2DomainEgressACLTemplates(l2Domains[i])
+			results[i] = egressACLTemplates
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, egressACLTemplates := range results {
+			inv.egressACLTemplates = append(inv.egressACLTemplates, egressACLTemplates...)
+		}
+		return nil
+	})
+	return inv.egressACLTemplates, err
+}
+
+func (inv *VsdInventory) IngressACLEntryTemplates() (vspk.IngressACLEntryTemplatesList, error) {
+	err := inv.fetch("ingressaclentrytemplates", func() error {
+		inv.ingressACLEntryTemplates = nil
+
+		ingressACLTemplates, err := inv.IngressACLTemplates()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.IngressACLEntryTemplatesList, len(ingressACLTemplates))
+		err = runParallel(inv.concurrency, len(ingressACLTemplates), func(i int) error {
+			entries, err := inv.session.FetchAllIngressACLEntryTemplates(ingressACLTemplates[i])
+			results[i] = entries
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, entries := range results {
+			inv.ingressACLEntryTemplates = append(inv.ingressACLEntryTemplates, entries...)
+		}
+		return nil
+	})
+	return inv.ingressACLEntryTemplates, err
+}
+
+func (inv *VsdInventory) EgressACLEntryTemplates() (vspk.EgressACLEntryTemplatesList, error) {
+	err := inv.fetch("egressaclentrytemplates", func() error {
+		inv.egressACLEntryTemplates = nil
+
+		egressACLTemplates, err := inv.EgressACLTemplates()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.EgressACLEntryTemplatesList, len(egressACLTemplates))
+		err = runParallel(inv.concurrency, len(egressACLTemplates), func(i int) error {
+			entries, err := inv.session.FetchAllEgressACLEntryTemplates(egressACLTemplates[i])
+			results[i] = entries
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, entries := range results {
+			inv.egressACLEntryTemplates = append(inv.egressACLEntryTemplates, entries...)
+		}
+		return nil
+	})
+	return inv.egressACLEntryTemplates, err
+}
+
+func (inv *VsdInventory) SharedNetworkResources() (vspk.SharedNetworkResourcesList, error) {
+	err := inv.fetch("sharednetworkresources", func() error {
+		sharedNetworkResources, err := inv.session.FetchAllSharedNetworkResources()
+		inv.sharedNetworkResources = sharedNetworkResources
+		return err
+	})
+	return inv.sharedNetworkResources, err
+}
+
+// FloatingIps returns the floating ips of all domains
+func (inv *VsdInventory) FloatingIps() (vspk.FloatingIpsList, error) {
+	err := inv.fetch("floatingips", func() error {
+		inv.floatingIps = nil
+
+		domains, err := inv.Domains()
+		if err != nil {
+			return err
+		}
+		results := make([]vspk.FloatingIpsList, len(domains))
+		err = runParallel(inv.concurrency, len(domains), func(i int) error {
+			floatingIps, err := inv.session.FetchAllDomainFloatingIps(domains[i], inv.filter)
+			results[i] = floatingIps
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, floatingIps := range results {
+			inv.floatingIps = append(inv.floatingIps, floatingIps...)
+		}
+		return nil
+	})
+	return inv.floatingIps, err
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunParallel(t *testing.T) {
+	var calls int32
+	err := runParallel(4, 100, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	if err != nil || calls != 100 {
+		t.Errorf("runParallel made %d calls and returned %v, expected 100 calls", calls, err)
+	}
+}
+
+func TestRunParallelStopsAtFirstError(t *testing.T) {
+	var calls int32
+	err := runParallel(4, 1000, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		return fmt.Errorf("failed %d", i)
+	})
+	if err == nil {
+		t.Fatal("runParallel returned no error")
+	}
+	// Every worker stops at its first error
+	if calls > 4 {
+		t.Errorf("runParallel made %d calls after the first error, expected at most 4", calls)
+	}
+}
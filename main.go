@@ -19,6 +19,9 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -28,84 +31,209 @@ const (
 	ResTypeDummyfip      string = "dummyfip"
 	ResTypeSecuritygroup string = "securitygroup"
 	ResTypeUnderlayacl   string = "underlayacl"
+	ResTypeAll           string = "all"
 )
 
+// Exit status of a scan
+const (
+	ExitClean        int = 0
+	ExitInconsistent int = 1
+	ExitFailed       int = 2
+)
+
+// Scanners of each resource type, in the order they run for the all resource type
+var scanners = []struct {
+	resourceType string
+	scan         func() ([]Finding, error)
+}{
+	{ResTypeSubnet, scanResForSubnet},
+	{ResTypeRouter, scanResForRouter},
+	{ResTypePort, scanResForPort},
+	{ResTypeDummyfip, scanResForDummyFip},
+	{ResTypeSecuritygroup, scanResForSecurityGroup},
+	{ResTypeUnderlayacl, scanResForUnderlayAcl},
+}
+
 var globalConfig *Config
 
-func printUsage() {
-	s := fmt.Sprintf(
-		`Usage:
-  nuageresscan [config] [%s|%s|%s|%s|%s|%s]
-
-Flags:
-  -h, --help             help for program
-  -v, --version          show program version
-  -i, --info             set log level to info
-}`, ResTypeSubnet, ResTypeRouter, ResTypePort, ResTypeDummyfip, ResTypeSecuritygroup, ResTypeUnderlayacl)
-	fmt.Println(s)
+// jobMutex is held by startJob while it runs, the global state of the scanners,
+// globalConfig, scanFilter, neutronSnapshot and the VSD sessions and inventories,
+// belongs to the running job and is only read or reset under it
+var jobMutex sync.Mutex
+
+// JobOptions holds the command line options of a job
+type JobOptions struct {
+	Output          string
+	Format          string
+	Plan            string
+	Yes             bool
+	Execute         bool
+	BackupDir       string
+	FromSnapshot    string
+	Previous        string
+	Suppressions    string
+	Check           bool
+	MetricsTextfile string
+	MetricsListen   string
+	Concurrency     int
+	Filter          ScanFilter
+
+	// Report of the previous run of serve, used instead of the Previous file
+	PreviousReport *Report
 }
 
-func startJob(configPath string, resourceType string) error {
-	config, err := LoadConfig(configPath)
-	if err != nil {
-		return fmt.Errorf("failed to load config: %s", err)
+func isResourceType(resourceType string) bool {
+	if resourceType == ResTypeAll {
+		return true
 	}
+	for _, scanner := range scanners {
+		if scanner.resourceType == resourceType {
+			return true
+		}
+	}
+	return false
+}
 
-	globalConfig = config
+// startJob scans the resource types, separated by commas, and returns the report,
+// which is nil when the scan could not start and has Errors when some scanners failed
+func startJob(configPath string, resourceType string, options *JobOptions) (*Report, error) {
+	resourceTypes := make(map[string]bool)
+	for _, rt := range strings.Split(resourceType, ",") {
+		if !isResourceType(rt) {
+			return nil, fmt.Errorf("unknown resource type %s", rt)
+		}
+		resourceTypes[rt] = true
+	}
 
-	neu := globalConfig.Neu
-	err = OpenDB(neu.Username, neu.Password, neu.IPAddr, neu.Port, neu.DBName)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %s", err)
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	// Nothing fetched by a previous job is reused
+	resetVsdSessions()
+	resetVsdInventories()
+	neutronSnapshot = nil
+	scanFilter = nil
+
+	startTime := time.Now()
+	if options.FromSnapshot != "" {
+		snapshot, err := ReadSnapshot(options.FromSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		LoadSnapshot(snapshot)
+	} else {
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %s", err)
+		}
+
+		globalConfig = config
+		if options.Concurrency > 0 {
+			globalConfig.Concurrency = options.Concurrency
+		}
+
+		neu := globalConfig.Neu
+		err = OpenDB(neu.Username, neu.Password, neu.IPAddr, neu.Port, neu.DBName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %s", err)
+		}
 	}
 
-	switch resourceType {
-	case ResTypeSubnet:
-		scanResForSubnet()
-	case ResTypeRouter:
-		scanResForRouter()
-	case ResTypePort:
-		scanResForPort()
-	case ResTypeDummyfip:
-		scanResForDummyFip()
-	case ResTypeSecuritygroup:
-		scanResForSecurityGroup()
-	case ResTypeUnderlayacl:
-		scanResForUnderlayAcl()
-	default:
+	if !options.Filter.IsEmpty() {
+		filter := options.Filter
+		err := filter.resolve(globalConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve filter: %s", err)
+		}
+		scanFilter = &filter
+	}
+
+	previous := options.PreviousReport
+	if options.Previous != "" {
+		var err error
+		previous, err = ReadReport(options.Previous)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read previous report: %s", err)
+		}
+	}
+
+	var suppressions []Suppression
+	if options.Suppressions != "" {
+		var err error
+		suppressions, err = LoadSuppressions(options.Suppressions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load suppressions: %s", err)
+		}
+	}
+
+	report := NewReport(globalConfig, startTime)
+	report.Snapshot = options.FromSnapshot
+	report.Filter = scanFilter
+	report.ExpiredSuppressions = expiredSuppressions(suppressions, startTime)
+	for _, suppression := range report.ExpiredSuppressions {
 		logrus.WithField("func", "startJob").
-			Error("Unknown resource type:" + resourceType)
-		printUsage()
+			Warningf("suppression expired on %s: %s", suppression.Expires, suppression.Reason)
 	}
-	return nil
-}
 
-func main() {
-	logrus.SetLevel(logrus.WarnLevel)
+	// All scanners share the database connection and the VSD sessions
+	var failedResourceTypes []string
+	for _, scanner := range scanners {
+		if !resourceTypes[ResTypeAll] && !resourceTypes[scanner.resourceType] {
+			continue
+		}
 
-	configPos := 1
-	if len(os.Args) > 1 {
-		if os.Args[1] == "-h" || os.Args[1] == "--help" {
-			printUsage()
-			os.Exit(0)
-		} else if os.Args[1] == "-v" || os.Args[1] == "--version" {
-			fmt.Println("nuageresscan version", Version())
-			os.Exit(0)
-		} else if os.Args[1] == "-i" || os.Args[1] == "--info" {
-			logrus.SetLevel(logrus.InfoLevel)
-			configPos = 2
+		findings, err := scanner.scan()
+		if err != nil {
+			err = fmt.Errorf("failed to scan %s: %s", scanner.resourceType, err)
+			logrus.WithField("func", "startJob").Error(err)
+			report.Errors[scanner.resourceType] = err.Error()
+			failedResourceTypes = append(failedResourceTypes, scanner.resourceType)
+			continue
 		}
+
+		findings = filterFindings(findings)
+		findings, suppressed := filterSuppressedFindings(suppressions, findings, startTime)
+		if suppressed > 0 {
+			report.Suppressed[scanner.resourceType] = suppressed
+		}
+
+		logFindings(findings)
+		report.AddFindings(scanner.resourceType, findings)
 	}
+	report.EndTime = time.Now()
+	report.StampFirstSeen(previous)
 
-	if len(os.Args) > configPos+1 {
-		err := startJob(os.Args[configPos], os.Args[configPos+1])
+	err := notifyNewFindings(&globalConfig.Notify, report, previous)
+	if err != nil {
+		logrus.WithField("func", "startJob").Error(err)
+	}
+
+	if options.Plan != "" {
+		plan, err := NewPlan(report, time.Now())
+		if err != nil {
+			return report, fmt.Errorf("failed to generate plan: %s", err)
+		}
+		err = WritePlan(plan, options.Plan)
+		if err != nil {
+			return report, err
+		}
+		logrus.WithField("func", "startJob").
+			Infof("Wrote %d actions to plan %s", len(plan.Actions), options.Plan)
+	}
+	if options.Output != "" {
+		err := WriteReport(report, options.Output, options.Format)
 		if err != nil {
-			logrus.WithField("func", "main").Error(err)
-			os.Exit(1)
+			return report, fmt.Errorf("failed to write report: %s", err)
 		}
-		os.Exit(0)
 	}
+	if len(failedResourceTypes) > 0 {
+		return report, fmt.Errorf("failed to scan %s", strings.Join(failedResourceTypes, ", "))
+	}
+	return report, nil
+}
+
+func main() {
+	logrus.SetLevel(logrus.WarnLevel)
 
-	printUsage()
-	os.Exit(1)
+	os.Exit(runCommandLine(os.Args[1:]))
 }
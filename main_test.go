@@ -0,0 +1,33 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStartJobDropsPreviousSnapshot(t *testing.T) {
+	loadTestSnapshot(t, &Snapshot{Config: Config{Vsds: testVsds}})
+
+	_, err := startJob(filepath.Join(t.TempDir(), "missing.json"), ResTypeSubnet, &JobOptions{})
+	if err == nil {
+		t.Fatal("job without config did not fail")
+	}
+	if neutronSnapshot != nil {
+		t.Error("the snapshot of the previous job is still read instead of the database")
+	}
+}
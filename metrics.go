@@ -0,0 +1,206 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VSD API calls and failed calls of the process, by AZ
+var vsdAPICalls = make(map[string]uint64)
+var vsdAPIErrors = make(map[string]uint64)
+var vsdAPIMetricsMutex sync.Mutex
+
+// Report whose metrics are served on /metrics
+var metricsReport *Report
+var metricsReportMutex sync.Mutex
+
+func recordVsdAPICall(az string, failed bool) {
+	vsdAPIMetricsMutex.Lock()
+	defer vsdAPIMetricsMutex.Unlock()
+
+	vsdAPICalls[az]++
+	if failed {
+		vsdAPIErrors[az]++
+	}
+}
+
+// SetMetricsReport sets the report served on /metrics
+func SetMetricsReport(report *Report) {
+	metricsReportMutex.Lock()
+	defer metricsReportMutex.Unlock()
+
+	metricsReport = report
+}
+
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (m *metricsWriter) header(name string, metricType string, help string) {
+	fmt.Fprintf(&m.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes one sample, labels are pairs of label name and value
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.b.WriteString(name)
+	if len(labels) > 0 {
+		var pairs []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			labelValue := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelValue))
+		}
+		m.b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(&m.b, " %g\n", value)
+}
+
+// WriteMetrics writes the metrics of the report, which may be nil before the
+// first scan, and of the VSD API calls in Prometheus text format
+func WriteMetrics(w io.Writer, report *Report) error {
+	m := &metricsWriter{}
+
+	if report != nil {
+		m.header("nuageresscan_findings", "gauge", "Inconsistencies found by the last scan.")
+		for _, resourceType := range report.ResourceTypes() {
+			// Samples of every AZ are written, with 0 when clean, so that series do not vanish
+			counts := make(map[[2]string]int)
+			for _, vsd := range report.Vsds {
+				counts[[2]string{vsd.AZ, SideNeutron}] += 0
+				counts[[2]string{vsd.AZ, SideNuage}] += 0
+			}
+			for _, finding := range report.Findings[resourceType] {
+				counts[[2]string{finding.AZ, finding.Side}]++
+			}
+			var keys [][2]string
+			for key := range counts {
+				keys = append(keys, key)
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+			})
+			for _, key := range keys {
+				m.sample("nuageresscan_findings", float64(counts[key]), "resource_type", resourceType, "az", key[0], "side", key[1])
+			}
+		}
+
+		m.header("nuageresscan_suppressed_findings", "gauge", "Inconsistencies of the last scan hidden by a suppression.")
+		for _, resourceType := range report.ResourceTypes() {
+			m.sample("nuageresscan_suppressed_findings", float64(report.Suppressed[resourceType]), "resource_type", resourceType)
+		}
+
+		m.header("nuageresscan_expired_suppressions", "gauge", "Suppressions which have expired.")
+		m.sample("nuageresscan_expired_suppressions", float64(len(report.ExpiredSuppressions)))
+
+		var resourceTypes []string
+		for resourceType := range report.Findings {
+			resourceTypes = append(resourceTypes, resourceType)
+		}
+		for resourceType := range report.Errors {
+			resourceTypes = append(resourceTypes, resourceType)
+		}
+		sort.Strings(resourceTypes)
+		m.header("nuageresscan_scan_success", "gauge", "Whether the last scan of the resource type succeeded.")
+		for _, resourceType := range resourceTypes {
+			success := 1.0
+			if report.Errors[resourceType] != "" {
+				success = 0
+			}
+			m.sample("nuageresscan_scan_success", success, "resource_type", resourceType)
+		}
+
+		m.header("nuageresscan_scan_start_timestamp_seconds", "gauge", "Start time of the last scan.")
+		m.sample("nuageresscan_scan_start_timestamp_seconds", float64(report.StartTime.Unix()))
+		m.header("nuageresscan_scan_duration_seconds", "gauge", "Duration of the last scan.")
+		m.sample("nuageresscan_scan_duration_seconds", report.EndTime.Sub(report.StartTime).Seconds())
+	}
+
+	vsdAPIMetricsMutex.Lock()
+	var azs []string
+	for az := range vsdAPICalls {
+		azs = append(azs, az)
+	}
+	sort.Strings(azs)
+	m.header("nuageresscan_vsd_api_calls_total", "counter", "Calls to the VSD API.")
+	for _, az := range azs {
+		m.sample("nuageresscan_vsd_api_calls_total", float64(vsdAPICalls[az]), "az", az)
+	}
+	m.header("nuageresscan_vsd_api_errors_total", "counter", "Failed calls to the VSD API.")
+	for _, az := range azs {
+		m.sample("nuageresscan_vsd_api_errors_total", float64(vsdAPIErrors[az]), "az", az)
+	}
+	vsdAPIMetricsMutex.Unlock()
+
+	_, err := io.WriteString(w, m.b.String())
+	return err
+}
+
+// WriteMetricsTextfile writes the metrics for the node_exporter textfile collector,
+// through a temporary file so that node_exporter never reads a partial file
+func WriteMetricsTextfile(report *Report, path string) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	err = WriteMetrics(file, report)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// ServeMetrics serves /metrics on addr until the process is stopped, with the
+// timeouts of serve so that slow clients do not hold connections forever
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", MetricsHandler)
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: serveReadHeaderTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	return httpServer.ListenAndServe()
+}
+
+// MetricsHandler serves the metrics of the report set by SetMetricsReport
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsReportMutex.Lock()
+	report := metricsReport
+	metricsReportMutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w, report)
+}
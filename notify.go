@@ -0,0 +1,245 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Timeout of a webhook request and of a syslog connection
+const notifyTimeout = 30 * time.Second
+
+// Enterprise number of the structured data of the syslog messages
+const syslogEnterpriseID = "32473"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Severity warning of RFC 5424
+const syslogSeverityWarning = 4
+
+// Notification is the data of the webhook template and the JSON payload of the webhook
+type Notification struct {
+	Version     string    `json:"version"`
+	NeutronHost string    `json:"neutron_host"`
+	StartTime   time.Time `json:"start_time"`
+	Findings    []Finding `json:"findings"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		buf, err := json.Marshal(v)
+		return string(buf), err
+	},
+}
+
+const defaultSyslogTemplate = `{{.ResourceType}} {{.Side}} az={{.AZ}} neutron_id={{.NeutronID}} vsd_id={{.VsdID}} {{.Code}}: {{.Message}}`
+
+// newFindings returns the findings of the report which are not in the previous report,
+// only the resource types scanned without errors in both reports are compared
+func newFindings(report *Report, previous *Report) []Finding {
+	var findings []Finding
+	for _, diffFinding := range DiffReports(previous, report).Added {
+		findings = append(findings, diffFinding.Finding)
+	}
+	return findings
+}
+
+// notifyNewFindings sends the findings of the report which are not in the previous
+// report to the webhook and the syslog of the config, nothing is sent without a
+// previous report as every finding would be new
+func notifyNewFindings(config *NotifyConfig, report *Report, previous *Report) error {
+	if config.Webhook == nil && config.Syslog == nil {
+		return nil
+	}
+	if previous == nil {
+		logrus.WithField("func", "notifyNewFindings").
+			Info("No previous report to find the new findings, skip notifications")
+		return nil
+	}
+
+	findings := newFindings(report, previous)
+	logrus.WithField("func", "notifyNewFindings").
+		Infof("Notify %d new findings", len(findings))
+	if len(findings) <= 0 {
+		return nil
+	}
+
+	notification := &Notification{
+		Version:     report.Version,
+		NeutronHost: report.NeutronHost,
+		StartTime:   report.StartTime,
+		Findings:    findings,
+	}
+	var errs []string
+	if config.Webhook != nil {
+		err := sendWebhook(config.Webhook, notification)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to send webhook: %s", err))
+		}
+	}
+	if config.Syslog != nil {
+		err := sendSyslog(config.Syslog, notification)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to send syslog: %s", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func sendWebhook(config *WebhookConfig, notification *Notification) error {
+	var body bytes.Buffer
+	if config.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(config.Template)
+		if err != nil {
+			return fmt.Errorf("invalid template: %s", err)
+		}
+		err = tmpl.Execute(&body, notification)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := json.NewEncoder(&body).Encode(notification)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, config.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", config.URL, resp.Status)
+	}
+	return nil
+}
+
+// syslogParamValue escapes a value of a structured data parameter
+func syslogParamValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// syslogMessage formats the finding as an RFC 5424 message, the IDs of the
+// finding are in the structured data
+func syslogMessage(facility int, hostname string, finding *Finding, message string, t time.Time) string {
+	var params []string
+	for _, param := range [][2]string{
+		{"resource_type", finding.ResourceType},
+		{"side", finding.Side},
+		{"az", finding.AZ},
+		{"neutron_id", finding.NeutronID},
+		{"vsd_id", finding.VsdID},
+		{"external_id", finding.ExternalID},
+		{"code", finding.Code},
+		{"fingerprint", finding.Fingerprint},
+	} {
+		if param[1] != "" {
+			params = append(params, fmt.Sprintf(`%s="%s"`, param[0], syslogParamValue(param[1])))
+		}
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s nuageresscan %d %s [finding@%s %s] %s",
+		facility*8+syslogSeverityWarning, t.UTC().Format(time.RFC3339Nano), hostname, os.Getpid(),
+		finding.Code, syslogEnterpriseID, strings.Join(params, " "), message)
+}
+
+func sendSyslog(config *SyslogConfig, notification *Notification) error {
+	network := config.Network
+	if network == "" {
+		network = "udp"
+	}
+	if network != "udp" && network != "tcp" {
+		return fmt.Errorf("unknown network %s", network)
+	}
+	facility := syslogFacilities["user"]
+	if config.Facility != "" {
+		var ok bool
+		facility, ok = syslogFacilities[config.Facility]
+		if !ok {
+			return fmt.Errorf("unknown facility %s", config.Facility)
+		}
+	}
+	messageTemplate := config.Template
+	if messageTemplate == "" {
+		messageTemplate = defaultSyslogTemplate
+	}
+	tmpl, err := template.New("syslog").Funcs(templateFuncs).Parse(messageTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid template: %s", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	conn, err := net.DialTimeout(network, config.Address, notifyTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i := 0; i < len(notification.Findings); i++ {
+		var message strings.Builder
+		err = tmpl.Execute(&message, &notification.Findings[i])
+		if err != nil {
+			return err
+		}
+		line := syslogMessage(facility, hostname, &notification.Findings[i], message.String(), time.Now())
+
+		conn.SetWriteDeadline(time.Now().Add(notifyTimeout))
+		if network == "tcp" {
+			// Octet counting framing of RFC 6587
+			_, err = fmt.Fprintf(conn, "%d %s", len(line), line)
+		} else {
+			_, err = io.WriteString(conn, line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
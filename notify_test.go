@@ -0,0 +1,237 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNotifyFindings = []Finding{
+	{ResourceType: ResTypeSubnet, Side: "neutron", NeutronID: "s1", VsdID: "l1", AZ: "bj", Code: CodeL2DomainMissing, Message: "l2domain l1 was not found"},
+	{ResourceType: ResTypeSubnet, Side: "nuage", VsdID: "l9", ExternalID: "s9@cms-bj", AZ: "bj", Code: CodeL2DomainOrphan, Message: "l2domain \"l9\" [é] is orphan"},
+}
+
+// testNotifyReports returns a previous report with the first finding and a report with both
+func testNotifyReports() (*Report, *Report) {
+	startTime := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	config := &Config{Neu: Neutron{IPAddr: "192.168.0.10", Port: 3306}}
+	previous := NewReport(config, startTime)
+	previous.AddFindings(ResTypeSubnet, testNotifyFindings[:1])
+	report := NewReport(config, startTime.Add(time.Hour))
+	report.AddFindings(ResTypeSubnet, testNotifyFindings)
+	return report, previous
+}
+
+// webhookRecorder is a webhook keeping the last request it received
+type webhookRecorder struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newWebhookServer(t *testing.T, recorder *webhookRecorder) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.header = r.Header
+		recorder.body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(recorder.status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNotifyNewFindingsWebhookPayload(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusNoContent}
+	server := newWebhookServer(t, recorder)
+	report, previous := testNotifyReports()
+
+	config := &NotifyConfig{Webhook: &WebhookConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}}
+	err := notifyNewFindings(config, report, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.header.Get("Content-Type") != "application/json" || recorder.header.Get("Authorization") != "Bearer token" {
+		t.Errorf("webhook headers %v", recorder.header)
+	}
+	notification := &Notification{}
+	err = json.Unmarshal(recorder.body, notification)
+	if err != nil {
+		t.Fatalf("invalid payload %s: %s", recorder.body, err)
+	}
+	if notification.NeutronHost != "192.168.0.10:3306" || !notification.StartTime.Equal(report.StartTime) {
+		t.Errorf("payload %s does not describe the report", recorder.body)
+	}
+	if len(notification.Findings) != 1 || notification.Findings[0].VsdID != "l9" || notification.Findings[0].Fingerprint == "" {
+		t.Errorf("payload %s does not hold the new finding only", recorder.body)
+	}
+}
+
+func TestSendWebhookTemplate(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusOK}
+	server := newWebhookServer(t, recorder)
+
+	template := `{"text": "{{len .Findings}} new findings on {{.NeutronHost}}"{{range .Findings}}, "{{.VsdID}}": {{json .Message}}{{end}}}`
+	notification := &Notification{NeutronHost: "192.168.0.10:3306", Findings: testNotifyFindings}
+	err := sendWebhook(&WebhookConfig{URL: server.URL, Template: template}, notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"text": "2 new findings on 192.168.0.10:3306", "l1": "l2domain l1 was not found", "l9": "l2domain \"l9\" [é] is orphan"}`
+	if string(recorder.body) != expected {
+		t.Errorf("body %s, expected %s", recorder.body, expected)
+	}
+}
+
+func TestSendWebhookFailure(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusBadGateway}
+	server := newWebhookServer(t, recorder)
+
+	err := sendWebhook(&WebhookConfig{URL: server.URL}, &Notification{Findings: testNotifyFindings})
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("webhook answering 502 returned %v", err)
+	}
+}
+
+// checkSyslogMessages checks the RFC 5424 messages of the findings sent with facility local0
+func checkSyslogMessages(t *testing.T, messages []string) {
+	if len(messages) != len(testNotifyFindings) {
+		t.Fatalf("received %d messages %q, expected %d", len(messages), messages, len(testNotifyFindings))
+	}
+	for i, message := range messages {
+		finding := &testNotifyFindings[i]
+		prefix := fmt.Sprintf("<%d>1 ", 16*8+syslogSeverityWarning)
+		if !strings.HasPrefix(message, prefix) {
+			t.Errorf("message %q does not start with %q", message, prefix)
+		}
+		fields := strings.SplitN(strings.TrimPrefix(message, prefix), " ", 6)
+		if len(fields) != 6 {
+			t.Fatalf("message %q has not the RFC 5424 header", message)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			t.Errorf("message %q has an invalid timestamp: %s", message, err)
+		}
+		if fields[2] != "nuageresscan" || fields[4] != finding.Code {
+			t.Errorf("message %q has app name %s and message ID %s", message, fields[2], fields[4])
+		}
+		data := fmt.Sprintf(`[finding@%s resource_type="subnet" side="%s" az="bj"`, syslogEnterpriseID, finding.Side)
+		if !strings.HasPrefix(fields[5], data) {
+			t.Errorf("structured data of message %q does not start with %s", message, data)
+		}
+		if !strings.HasSuffix(message, "] "+finding.Code+": "+finding.Message) {
+			t.Errorf("message %q does not end with the finding", message)
+		}
+	}
+}
+
+func TestSendSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	config := &SyslogConfig{Address: conn.LocalAddr().String(), Facility: "local0", Template: "{{.Code}}: {{.Message}}"}
+	err = sendSyslog(config, &Notification{Findings: testNotifyFindings})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	buf := make([]byte, 65536)
+	for range testNotifyFindings {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, string(buf[:n]))
+	}
+	checkSyslogMessages(t, messages)
+}
+
+// readOctetCountedFrames reads the messages framed with octet counting until EOF
+func readOctetCountedFrames(r io.Reader) ([]string, error) {
+	reader := bufio.NewReader(r)
+	var messages []string
+	for {
+		length, err := reader.ReadString(' ')
+		if err == io.EOF && length == "" {
+			return messages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame length %q", length)
+		}
+		message := make([]byte, n)
+		_, err = io.ReadFull(reader, message)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, string(message))
+	}
+}
+
+func TestSendSyslogTCPOctetCounting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	type frames struct {
+		messages []string
+		err      error
+	}
+	received := make(chan frames, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- frames{err: err}
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		messages, err := readOctetCountedFrames(conn)
+		received <- frames{messages, err}
+	}()
+
+	config := &SyslogConfig{Network: "tcp", Address: listener.Addr().String(), Facility: "local0", Template: "{{.Code}}: {{.Message}}"}
+	err = sendSyslog(config, &Notification{Findings: testNotifyFindings})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := <-received
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	checkSyslogMessages(t, result.messages)
+}
@@ -0,0 +1,394 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// Object types of plan actions, deleted in this order so that no object
+// is deleted before the objects depending on it
+var planObjectIdentities = []bambou.Identity{
+	vspk.IngressACLEntryTemplateIdentity,
+	vspk.EgressACLEntryTemplateIdentity,
+	vspk.VMInterfaceIdentity,
+	vspk.VPortIdentity,
+	vspk.PolicyGroupIdentity,
+	vspk.FloatingIpIdentity,
+	vspk.SubnetIdentity,
+	vspk.L2DomainIdentity,
+	vspk.DomainIdentity,
+	vspk.DomainTemplateIdentity,
+	vspk.L2DomainTemplateIdentity,
+}
+
+// PlanAction is the proposed delete of one orphan VSD object, LastUpdatedDate is
+// the one of the object when the plan was made, in milliseconds since the epoch
+type PlanAction struct {
+	ObjectType      string `json:"object_type"`
+	ID              string `json:"id"`
+	Name            string `json:"name,omitempty"`
+	ExternalID      string `json:"external_id"`
+	LastUpdatedDate int64  `json:"last_updated_date"`
+	ParentType      string `json:"parent_type"`
+	ParentID        string `json:"parent_id"`
+	AZ              string `json:"az"`
+	VsdURL          string `json:"vsd_url"`
+	ResourceType    string `json:"resource_type"`
+	Code            string `json:"code"`
+	Reason          string `json:"reason"`
+}
+
+// Plan is the list of delete actions proposed for the orphan VSD objects of a report
+type Plan struct {
+	Version     string       `json:"version"`
+	CreatedTime time.Time    `json:"created_time"`
+	NeutronHost string       `json:"neutron_host"`
+	Actions     []PlanAction `json:"actions"`
+}
+
+func (a *PlanAction) withObject(objectType, name, externalID, parentType, parentID string) *PlanAction {
+	a.ObjectType = objectType
+	a.Name = name
+	a.ExternalID = externalID
+	a.ParentType = parentType
+	a.ParentID = parentID
+	return a
+}
+
+func planObjectTypeRank(objectType string) int {
+	for i, identity := range planObjectIdentities {
+		if identity.Name == objectType {
+			return i
+		}
+	}
+	return len(planObjectIdentities)
+}
+
+// GetPlanObjectIdentity returns the vspk identity of a plan object type
+func GetPlanObjectIdentity(objectType string) (bambou.Identity, error) {
+	for _, identity := range planObjectIdentities {
+		if identity.Name == objectType {
+			return identity, nil
+		}
+	}
+	return bambou.Identity{}, fmt.Errorf("unknown object type %s", objectType)
+}
+
+// SortPlanActions orders the actions by dependency
+func SortPlanActions(actions []PlanAction) {
+	sort.SliceStable(actions, func(i, j int) bool {
+		return planObjectTypeRank(actions[i].ObjectType) < planObjectTypeRank(actions[j].ObjectType)
+	})
+}
+
+// findPlanAction looks the VSD object of an orphan finding up in the inventory of its VSD,
+// it returns nil when the finding is not about an orphan VSD object
+func findPlanAction(inventory *VsdInventory, finding Finding) (*PlanAction, error) {
+	action := &PlanAction{
+		ID:           finding.VsdID,
+		AZ:           finding.AZ,
+		VsdURL:       finding.VsdURL,
+		ResourceType: finding.ResourceType,
+		Code:         finding.Code,
+		Reason:       finding.Message,
+	}
+
+	// A dangling l2dom mapping points to a template, an l2domain or a subnet
+	dangling := finding.Code == CodeSubnetMappingDangling
+
+	if finding.Code == CodeL2DomainTemplateOrphan || dangling {
+		l2DomainTemplates, err := inventory.L2DomainTemplates()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range l2DomainTemplates {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.L2DomainTemplateIdentity.Name, o.Name, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	}
+	if finding.Code == CodeL2DomainOrphan || dangling {
+		l2Domains, err := inventory.L2Domains()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range l2Domains {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.L2DomainIdentity.Name, o.Name, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	}
+	if finding.Code == CodeVsdSubnetOrphan || dangling {
+		subnets, err := inventory.Subnets()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range subnets {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.SubnetIdentity.Name, o.Name, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	}
+
+	switch finding.Code {
+	case CodeDomainOrphan:
+		domains, err := inventory.Domains()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range domains {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.DomainIdentity.Name, o.Name, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	case CodeVPortOrphan:
+		vports, err := inventory.VPorts()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range vports {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.VPortIdentity.Name, o.Name, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	case CodeVMInterfaceOrphan:
+		vmInterfaces, err := inventory.VMInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range vmInterfaces {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.VMInterfaceIdentity.Name, o.Name, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	case CodePolicyGroupOrphan:
+		policyGroups, err := inventory.PolicyGroups()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range policyGroups {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.PolicyGroupIdentity.Name, o.Name, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	case CodeIngressACLEntryOrphan:
+		entries, err := inventory.IngressACLEntryTemplates()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range entries {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.IngressACLEntryTemplateIdentity.Name, o.Description, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	case CodeEgressACLEntryOrphan:
+		entries, err := inventory.EgressACLEntryTemplates()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range entries {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.EgressACLEntryTemplateIdentity.Name, o.Description, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	case CodeFloatingIpOrphan:
+		floatingIps, err := inventory.FloatingIps()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range floatingIps {
+			if o.ID == finding.VsdID {
+				return action.withObject(vspk.FloatingIpIdentity.Name, o.Address, o.ExternalID, o.ParentType, o.ParentID), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// fetchPlanObject fetches the object with all its attributes from the VSD of the
+// inventory, which is not connected when the scan read a snapshot
+func fetchPlanObject(inventory *VsdInventory, identity bambou.Identity, ID string) (*VsdObject, error) {
+	if inventory.session == nil || inventory.session.Session == nil {
+		return nil, fmt.Errorf("failed to fetch %s %s: no session to the VSD, a plan cannot be made from a snapshot", identity.Name, ID)
+	}
+	object, err := inventory.session.FetchObject(identity, ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s %s: %s", identity.Name, ID, err)
+	}
+	return object, nil
+}
+
+// planDomainTemplates adds the delete of the templates of the planned domains, a
+// template is left behind by the delete of its domain and only deleted when no
+// domain which is kept is instantiated from it
+func planDomainTemplates(plan *Plan, planned map[string]bool) error {
+	for i := 0; i < len(plan.Actions); i++ {
+		domainAction := plan.Actions[i]
+		if domainAction.ObjectType != vspk.DomainIdentity.Name {
+			continue
+		}
+		inventory, err := GetVsdInventory(GetVSDByAZ(globalConfig, domainAction.AZ))
+		if err != nil {
+			return err
+		}
+		domains, err := inventory.Domains()
+		if err != nil {
+			return err
+		}
+
+		templateID := ""
+		for _, domain := range domains {
+			if domain.ID == domainAction.ID {
+				templateID = domain.TemplateID
+			}
+		}
+		if templateID == "" || planned[templateID] {
+			continue
+		}
+		used := false
+		for _, domain := range domains {
+			if domain.TemplateID == templateID && !planned[domain.ID] {
+				used = true
+			}
+		}
+		if used {
+			continue
+		}
+
+		object, err := fetchPlanObject(inventory, vspk.DomainTemplateIdentity, templateID)
+		if err != nil {
+			return err
+		}
+		name, _ := object.Attributes["name"].(string)
+		parentType, _ := object.Attributes["parentType"].(string)
+		parentID, _ := object.Attributes["parentID"].(string)
+		action := &PlanAction{
+			ID:              templateID,
+			LastUpdatedDate: object.LastUpdatedDate(),
+			AZ:              domainAction.AZ,
+			VsdURL:          domainAction.VsdURL,
+			ResourceType:    domainAction.ResourceType,
+			Code:            domainAction.Code,
+			Reason:          fmt.Sprintf("template of domain %s which no other domain is instantiated from", domainAction.ID),
+		}
+		planned[templateID] = true
+		plan.Actions = append(plan.Actions, *action.withObject(vspk.DomainTemplateIdentity.Name, name, object.ExternalID(), parentType, parentID))
+	}
+	return nil
+}
+
+// NewPlan proposes a delete action for every orphan VSD object of the report,
+// ordered by dependency
+func NewPlan(report *Report, createdTime time.Time) (*Plan, error) {
+	plan := &Plan{
+		Version:     Version(),
+		CreatedTime: createdTime,
+		NeutronHost: report.NeutronHost,
+		Actions:     []PlanAction{},
+	}
+
+	planned := make(map[string]bool)
+	for _, resourceType := range report.ResourceTypes() {
+		for _, finding := range report.Findings[resourceType] {
+			if finding.Side != SideNeutron || finding.VsdID == "" || planned[finding.VsdID] {
+				continue
+			}
+			vsd := GetVSDByAZ(globalConfig, finding.AZ)
+			if vsd == nil {
+				logrus.WithFields(logrus.Fields{"func": "NewPlan", "object": "nuage"}).
+					Warningf("skip %s %s of unknown AZ", finding.Code, finding.VsdID)
+				continue
+			}
+			inventory, err := GetVsdInventory(vsd)
+			if err != nil {
+				return nil, err
+			}
+			action, err := findPlanAction(inventory, finding)
+			if err != nil {
+				return nil, err
+			}
+			if action == nil {
+				continue
+			}
+
+			// vspk does not know lastUpdatedDate, so fetch the raw object to record it
+			identity, err := GetPlanObjectIdentity(action.ObjectType)
+			if err != nil {
+				return nil, err
+			}
+			object, err := fetchPlanObject(inventory, identity, action.ID)
+			if err != nil {
+				return nil, err
+			}
+			action.LastUpdatedDate = object.LastUpdatedDate()
+
+			planned[action.ID] = true
+			plan.Actions = append(plan.Actions, *action)
+		}
+	}
+
+	err := planDomainTemplates(plan, planned)
+	if err != nil {
+		return nil, err
+	}
+
+	SortPlanActions(plan.Actions)
+
+	return plan, nil
+}
+
+// WritePlan writes the plan as indented JSON to the given file, "-" means stdout
+func WritePlan(plan *Plan, path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write plan %s: %s", path, err)
+	}
+	return nil
+}
+
+func ReadPlan(path string) (*Plan, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	err = json.Unmarshal(buf, plan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %s", path, err)
+	}
+	return plan, nil
+}
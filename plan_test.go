@@ -0,0 +1,88 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDomainOrphanReport returns a report of orphan domains of the VSD
+func testDomainOrphanReport(vsd VSD, domainIDs ...string) *Report {
+	report := &Report{Findings: make(map[string][]Finding)}
+	for _, domainID := range domainIDs {
+		report.Findings[ResTypeRouter] = append(report.Findings[ResTypeRouter], Finding{
+			ResourceType: ResTypeRouter,
+			Side:         SideNeutron,
+			VsdID:        domainID,
+			Code:         CodeDomainOrphan,
+		}.withVsd(&vsd))
+	}
+	return report
+}
+
+func TestNewPlanDeletesUnusedDomainTemplate(t *testing.T) {
+	fake := newFakeVsd(t)
+	vsd := fake.vsd()
+	resetVsdSessions()
+	resetVsdInventories()
+	globalConfig = &Config{Vsds: []VSD{vsd}}
+	t.Cleanup(func() {
+		resetVsdSessions()
+		resetVsdInventories()
+		globalConfig = nil
+	})
+
+	fake.add("domaintemplates", "dt-1", "enterprise", "enterprise", map[string]interface{}{"name": "t1", "externalID": "r1@cms-bj", "lastUpdatedDate": 1600000000000})
+	fake.add("domaintemplates", "dt-2", "enterprise", "enterprise", map[string]interface{}{"name": "t2", "externalID": "r2@cms-bj"})
+	fake.add("domains", "d-1", "enterprise", "enterprise", map[string]interface{}{"externalID": "r1@cms-bj", "templateID": "dt-1"})
+	fake.add("domains", "d-2", "enterprise", "enterprise", map[string]interface{}{"externalID": "r2@cms-bj", "templateID": "dt-2"})
+	fake.add("domains", "d-3", "enterprise", "enterprise", map[string]interface{}{"externalID": "r3@cms-bj", "templateID": "dt-2"})
+
+	plan, err := NewPlan(testDomainOrphanReport(vsd, "d-1", "d-2"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+	for _, action := range plan.Actions {
+		actions = append(actions, action.ObjectType+" "+action.ID)
+	}
+	// dt-2 is still the template of d-3
+	expected := []string{"domain d-1", "domain d-2", vspk.DomainTemplateIdentity.Name + " dt-1"}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("actions %q, expected %q", actions, expected)
+	}
+	template := plan.Actions[2]
+	if template.ExternalID != "r1@cms-bj" || template.LastUpdatedDate != 1600000000000 || template.ParentID != "enterprise" {
+		t.Errorf("template action %+v", template)
+	}
+}
+
+func TestNewPlanFromSnapshot(t *testing.T) {
+	loadTestSnapshot(t, &Snapshot{
+		Config: Config{Vsds: testVsds},
+		Vsds:   []VsdSnapshot{{AZ: "bj", Domains: vspk.DomainsList{{ID: "d-1", ExternalID: "r1@cms-bj"}}}, {AZ: "cs"}},
+	})
+
+	_, err := NewPlan(testDomainOrphanReport(testVsds[0], "d-1"), time.Now())
+	if err == nil || !strings.Contains(err.Error(), "snapshot") {
+		t.Errorf("plan from a snapshot returned %v", err)
+	}
+}
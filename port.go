@@ -15,5 +15,297 @@
 
 package main
 
-func scanResForPort() {
+import (
+	"fmt"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Finding codes
+const (
+	CodeVPortMissing                 string = "vport-missing"
+	CodeVPortOrphan                  string = "vport-orphan"
+	CodeVPortExternalIDInvalid       string = "vport-external-id-invalid"
+	CodeVMInterfaceOrphan            string = "vminterface-orphan"
+	CodeVMInterfaceExternalIDInvalid string = "vminterface-external-id-invalid"
+)
+
+// Device owners of ports which never get a vport on VSD
+var ignoredPortDeviceOwners = []string{
+	"network:router_interface",
+	"network:router_interface_distributed",
+	"network:router_ha_interface",
+	"network:router_gateway",
+	"network:floatingip",
+	"network:dhcp",
+}
+
+// Neutron resources
+var neutronPorts []Port
+var neutronIPAllocations []IPAllocation
+var neutronPortMap map[string]*Port
+var neutronPortSubnetIDsMap map[string][]string
+
+// Nuage resources
+var nuageVPorts vspk.VPortsList
+var nuageVMInterfaces vspk.VMInterfacesList
+var nuageVPortMap map[string]*vspk.VPort
+var nuageVPortVsdMap map[string]*VSD
+var nuageVMInterfaceVsdMap map[string]*VSD
+
+func dumpAllNeutronPortResources() error {
+	neutronPorts = nil
+	neutronIPAllocations = nil
+
+	logrus.WithField("func", "dumpAllNeutronPortResources").
+		Info("SelectAllPorts")
+	err := SelectAllPorts(&neutronPorts)
+	if err != nil {
+		return err
+	}
+	neutronPortMap = make(map[string]*Port)
+	for i := 0; i < len(neutronPorts); i++ {
+		port := &neutronPorts[i]
+		neutronPortMap[port.ID] = port
+	}
+
+	logrus.WithField("func", "dumpAllNeutronPortResources").
+		Info("SelectAllIPAllocations")
+	err = SelectAllIPAllocations(&neutronIPAllocations)
+	if err != nil {
+		return err
+	}
+	neutronPortSubnetIDsMap = make(map[string][]string)
+	for _, ipAllocation := range neutronIPAllocations {
+		neutronPortSubnetIDsMap[ipAllocation.PortID] = append(neutronPortSubnetIDsMap[ipAllocation.PortID], ipAllocation.SubnetID)
+	}
+
+	// The l2dom mappings tell which ports live on nuage managed subnets
+	return dumpAllNeutronSubnetResources()
+}
+
+func dumpAllNuageVPortResources() error {
+	nuageVPorts = nil
+	nuageVMInterfaces = nil
+	nuageVPortMap = make(map[string]*vspk.VPort)
+	nuageVPortVsdMap = make(map[string]*VSD)
+	nuageVMInterfaceVsdMap = make(map[string]*VSD)
+
+	// Fetch the VSDs in parallel, the loop below only reads the inventories
+	err := prefetchVsdInventories(func(inventory *VsdInventory) error {
+		_, err := inventory.VPorts()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.VMInterfaces()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(globalConfig.Vsds); i++ {
+		vsd := &globalConfig.Vsds[i]
+		inventory, err := GetVsdInventory(vsd)
+		if err != nil {
+			return err
+		}
+
+		vports, err := inventory.VPorts()
+		if err != nil {
+			return err
+		}
+		nuageVPorts = append(nuageVPorts, vports...)
+		for _, vport := range vports {
+			nuageVPortVsdMap[vport.ID] = vsd
+		}
+
+		vmInterfaces, err := inventory.VMInterfaces()
+		if err != nil {
+			return err
+		}
+		nuageVMInterfaces = append(nuageVMInterfaces, vmInterfaces...)
+		for _, vmInterface := range vmInterfaces {
+			nuageVMInterfaceVsdMap[vmInterface.ID] = vsd
+		}
+	}
+
+	for _, vport := range nuageVPorts {
+		if vport.ExternalID == "" {
+			continue
+		}
+		if nuageVPortMap[vport.ExternalID] != nil {
+			logrus.WithFields(logrus.Fields{"func": "dumpAllNuageVPortResources", "object": "nuage"}).
+				Warning("found redundant vport " + vport.ExternalID)
+			continue
+		}
+		nuageVPortMap[vport.ExternalID] = vport
+	}
+
+	return nil
+}
+
+// isNuagePort tells whether the nuage plugin is expected to have created a vport for the port
+func isNuagePort(port *Port) bool {
+	for _, deviceOwner := range ignoredPortDeviceOwners {
+		if port.DeviceOwner == deviceOwner {
+			return false
+		}
+	}
+
+	if !port.VifType.Valid || port.VifType.String == "unbound" || port.VifType.String == "binding_failed" {
+		return false
+	}
+
+	if port.VnicType.Valid && port.VnicType.String != "normal" {
+		return false
+	}
+
+	for _, subnetID := range neutronPortSubnetIDsMap[port.ID] {
+		if neutronL2domMappingSubnetIDMap[subnetID] != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// portVsd returns the VSD of the first nuage managed subnet of the port whose VSD is known
+func portVsd(port *Port) *VSD {
+	for _, subnetID := range neutronPortSubnetIDsMap[port.ID] {
+		l2domMapping := neutronL2domMappingSubnetIDMap[subnetID]
+		if l2domMapping == nil {
+			continue
+		}
+		if vsd := mappingVsd(l2domMapping); vsd != nil {
+			return vsd
+		}
+	}
+	return nil
+}
+
+func scanResForPortBaseOnNeutron() []Finding {
+	var findings []Finding
+	for i := 0; i < len(neutronPorts); i++ {
+		neutronPort := &neutronPorts[i]
+		if !isNuagePort(neutronPort) {
+			continue
+		}
+
+		// The vport is only looked for on the VSD of the port when it is known
+		vsd := portVsd(neutronPort)
+		if scanFilter.skipsVsd(vsd) {
+			continue
+		}
+		var nuageVPort *vspk.VPort
+		if vsd != nil {
+			nuageVPort = nuageVPortMap[neutronPort.ID+"@"+vsd.CMSID]
+			if nuageVPort != nil && nuageVPortVsdMap[nuageVPort.ID] != vsd {
+				nuageVPort = nil
+			}
+		} else {
+			for _, vsd := range globalConfig.Vsds {
+				nuageVPort = nuageVPortMap[neutronPort.ID+"@"+vsd.CMSID]
+				if nuageVPort != nil {
+					break
+				}
+			}
+		}
+		if nuageVPort == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypePort,
+				Side:         SideNuage,
+				NeutronID:    neutronPort.ID,
+				Code:         CodeVPortMissing,
+				Message:      fmt.Sprintf("vport of port %s (device_owner %s) was not found", neutronPort.ID, neutronPort.DeviceOwner),
+			}.withVsd(vsd))
+		}
+	}
+
+	return findings
+}
+
+func scanResForPortBaseOnNuage() []Finding {
+	var findings []Finding
+	for _, nuageVPort := range nuageVPorts {
+		if nuageVPort.ExternalID == "" {
+			// vports created by VSD itself, e.g. for gateways, have no externalID
+			logrus.WithFields(logrus.Fields{"func": "scanResForPortBaseOnNuage", "object": "nuage"}).
+				Infof("found vport %s with empty externalID", nuageVPort.ID)
+			continue
+		}
+		neutronPortID := strings.Split(nuageVPort.ExternalID, "@")[0]
+		if neutronPortID == "" {
+			findings = append(findings, Finding{
+				ResourceType: ResTypePort,
+				Side:         SideNuage,
+				VsdID:        nuageVPort.ID,
+				ExternalID:   nuageVPort.ExternalID,
+				Code:         CodeVPortExternalIDInvalid,
+				Message:      "invalid vport externalID " + nuageVPort.ExternalID,
+			}.withVsd(nuageVPortVsdMap[nuageVPort.ID]))
+			continue
+		}
+		neutronPort := neutronPortMap[neutronPortID]
+		if neutronPort == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypePort,
+				Side:         SideNeutron,
+				NeutronID:    neutronPortID,
+				VsdID:        nuageVPort.ID,
+				ExternalID:   nuageVPort.ExternalID,
+				Code:         CodeVPortOrphan,
+				Message:      fmt.Sprintf("port.id %s of vport %s was not found", neutronPortID, nuageVPort.ID),
+			}.withVsd(nuageVPortVsdMap[nuageVPort.ID]))
+		}
+	}
+
+	for _, nuageVMInterface := range nuageVMInterfaces {
+		if nuageVMInterface.ExternalID == "" {
+			continue
+		}
+		neutronPortID := strings.Split(nuageVMInterface.ExternalID, "@")[0]
+		if neutronPortID == "" {
+			findings = append(findings, Finding{
+				ResourceType: ResTypePort,
+				Side:         SideNuage,
+				VsdID:        nuageVMInterface.ID,
+				ExternalID:   nuageVMInterface.ExternalID,
+				Code:         CodeVMInterfaceExternalIDInvalid,
+				Message:      "invalid vminterface externalID " + nuageVMInterface.ExternalID,
+			}.withVsd(nuageVMInterfaceVsdMap[nuageVMInterface.ID]))
+			continue
+		}
+		neutronPort := neutronPortMap[neutronPortID]
+		if neutronPort == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypePort,
+				Side:         SideNeutron,
+				NeutronID:    neutronPortID,
+				VsdID:        nuageVMInterface.ID,
+				ExternalID:   nuageVMInterface.ExternalID,
+				Code:         CodeVMInterfaceOrphan,
+				Message:      fmt.Sprintf("port.id %s of vminterface %s was not found", neutronPortID, nuageVMInterface.ID),
+			}.withVsd(nuageVMInterfaceVsdMap[nuageVMInterface.ID]))
+		}
+	}
+
+	return findings
+}
+
+func scanResForPort() ([]Finding, error) {
+	err := dumpAllNeutronPortResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNeutronPortResources: %s", err)
+	}
+
+	err = dumpAllNuageVPortResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNuageVPortResources: %s", err)
+	}
+
+	findings := scanResForPortBaseOnNeutron()
+	findings = append(findings, scanResForPortBaseOnNuage()...)
+	return findings, nil
 }
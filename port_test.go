@@ -0,0 +1,116 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"reflect"
+	"testing"
+)
+
+// testNuagePort returns a bound port of a VM
+func testNuagePort(id string) Port {
+	return Port{ID: id, DeviceOwner: "compute:nova", VifType: nullString("ovs"), VnicType: nullString("normal")}
+}
+
+func TestScanResForPort(t *testing.T) {
+	routerPort := testNuagePort("p-router")
+	routerPort.DeviceOwner = "network:router_interface"
+	unboundPort := testNuagePort("p-unbound")
+	unboundPort.VifType = nullString("unbound")
+	directPort := testNuagePort("p-direct")
+	directPort.VnicType = nullString("direct")
+
+	for _, test := range []struct {
+		name         string
+		ports        []Port
+		vports       vspk.VPortsList
+		csVPorts     vspk.VPortsList
+		vmInterfaces vspk.VMInterfacesList
+		expected     []string
+	}{
+		{
+			name:         "in sync",
+			ports:        []Port{testNuagePort("p1")},
+			vports:       vspk.VPortsList{{ID: "v1", ExternalID: "p1@cms-bj"}},
+			vmInterfaces: vspk.VMInterfacesList{{ID: "i1", ExternalID: "p1@cms-bj"}},
+			expected:     []string{},
+		},
+		{
+			name:     "missing",
+			ports:    []Port{testNuagePort("p1")},
+			expected: []string{"vport-missing p1  bj"},
+		},
+		{
+			name:     "missing on the VSD of the subnet",
+			ports:    []Port{testNuagePort("p1")},
+			csVPorts: vspk.VPortsList{{ID: "v1", ExternalID: "p1@cms-cs"}},
+			expected: []string{"vport-missing p1  bj"},
+		},
+		{
+			name:     "not nuage",
+			ports:    []Port{routerPort, unboundPort, directPort, testNuagePort("p-unmapped")},
+			expected: []string{},
+		},
+		{
+			name:         "orphan",
+			vports:       vspk.VPortsList{{ID: "v1", ExternalID: "p1@cms-bj"}, {ID: "v-gateway"}},
+			vmInterfaces: vspk.VMInterfacesList{{ID: "i1", ExternalID: "p1@cms-bj"}},
+			expected:     []string{"vminterface-orphan p1 i1 bj", "vport-orphan p1 v1 bj"},
+		},
+		{
+			name:         "external ID invalid",
+			ports:        []Port{testNuagePort("p1")},
+			vports:       vspk.VPortsList{{ID: "v1", ExternalID: "p1@cms-bj"}, {ID: "v2", ExternalID: "@cms-bj"}},
+			vmInterfaces: vspk.VMInterfacesList{{ID: "i2", ExternalID: "@cms-bj"}},
+			expected:     []string{"vminterface-external-id-invalid  i2 bj", "vport-external-id-invalid  v2 bj"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var ipAllocations []IPAllocation
+			for _, port := range test.ports {
+				subnetID := "s1"
+				if port.ID == "p-unmapped" {
+					subnetID = "s-unmapped"
+				}
+				ipAllocations = append(ipAllocations, IPAllocation{PortID: port.ID, SubnetID: subnetID})
+			}
+			loadTestSnapshot(t, &Snapshot{
+				Config: Config{Vsds: testVsds},
+				Neutron: NeutronSnapshot{
+					Subnets: []Subnet{{ID: "s1"}, {ID: "s-unmapped"}},
+					NuageSubnetL2domMappings: []NuageSubnetL2domMapping{
+						{SubnetID: "s1", NuageSubnetID: "l1", NetPartitionName: nullString("OpenStack_bj")},
+					},
+					Ports:         test.ports,
+					IPAllocations: ipAllocations,
+				},
+				Vsds: []VsdSnapshot{
+					{AZ: "bj", VPorts: test.vports, VMInterfaces: test.vmInterfaces},
+					{AZ: "cs", VPorts: test.csVPorts},
+				},
+			})
+
+			findings, err := scanResForPort()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := findingKeys(findings); !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("findings %q, expected %q", keys, test.expected)
+			}
+		})
+	}
+}
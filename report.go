@@ -0,0 +1,168 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+type ReportVsd struct {
+	AZ           string `json:"az"`
+	URL          string `json:"url"`
+	NetPartition string `json:"net_partition"`
+}
+
+// Report is the result of one run, findings are grouped by resource type and
+// Suppressed counts the findings hidden by a suppression of each resource type.
+// Filter is set when the scan was restricted to some AZs, a project or some IDs.
+type Report struct {
+	Version             string               `json:"version"`
+	StartTime           time.Time            `json:"start_time"`
+	EndTime             time.Time            `json:"end_time"`
+	NeutronHost         string               `json:"neutron_host"`
+	Snapshot            string               `json:"snapshot,omitempty"`
+	Filter              *ScanFilter          `json:"filter,omitempty"`
+	Vsds                []ReportVsd          `json:"vsds"`
+	Findings            map[string][]Finding `json:"findings"`
+	Errors              map[string]string    `json:"errors,omitempty"`
+	Suppressed          map[string]int       `json:"suppressed,omitempty"`
+	ExpiredSuppressions []Suppression        `json:"expired_suppressions,omitempty"`
+}
+
+func NewReport(config *Config, startTime time.Time) *Report {
+	report := &Report{
+		Version:     Version(),
+		StartTime:   startTime,
+		NeutronHost: fmt.Sprintf("%s:%d", config.Neu.IPAddr, config.Neu.Port),
+		Findings:    make(map[string][]Finding),
+		Errors:      make(map[string]string),
+		Suppressed:  make(map[string]int),
+	}
+	for _, vsd := range config.Vsds {
+		report.Vsds = append(report.Vsds, ReportVsd{AZ: vsd.AZ, URL: vsd.URL, NetPartition: vsd.NetPartition})
+	}
+
+	return report
+}
+
+func (r *Report) AddFindings(resourceType string, findings []Finding) {
+	// Keep an empty list so that clean resource types show up in the report
+	if r.Findings[resourceType] == nil {
+		r.Findings[resourceType] = []Finding{}
+	}
+	for _, finding := range findings {
+		finding.Fingerprint = finding.fingerprint()
+		r.Findings[resourceType] = append(r.Findings[resourceType], finding)
+	}
+}
+
+// StampFirstSeen sets when each finding was first seen, the findings already in
+// the previous report keep the time they were first seen there
+func (r *Report) StampFirstSeen(previous *Report) {
+	firstSeenMap := make(map[string]time.Time)
+	if previous != nil {
+		for _, findings := range previous.Findings {
+			for _, finding := range findings {
+				if finding.FirstSeen != nil {
+					firstSeenMap[finding.Fingerprint] = *finding.FirstSeen
+				} else {
+					firstSeenMap[finding.Fingerprint] = previous.StartTime
+				}
+			}
+		}
+	}
+
+	for _, findings := range r.Findings {
+		for i := 0; i < len(findings); i++ {
+			firstSeen, ok := firstSeenMap[findings[i].Fingerprint]
+			if !ok {
+				firstSeen = r.StartTime
+			}
+			findings[i].FirstSeen = &firstSeen
+		}
+	}
+}
+
+// FindingCount returns the number of findings of all resource types
+func (r *Report) FindingCount() int {
+	count := 0
+	for _, findings := range r.Findings {
+		count += len(findings)
+	}
+	return count
+}
+
+// ResourceTypes returns the resource types of the report in a stable order
+func (r *Report) ResourceTypes() []string {
+	var resourceTypes []string
+	for resourceType := range r.Findings {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+	return resourceTypes
+}
+
+// ReadReport reads a report written in JSON format
+func ReadReport(path string) (*Report, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	err = json.Unmarshal(buf, report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %s", path, err)
+	}
+
+	// Reports of older versions have no fingerprints
+	for _, findings := range report.Findings {
+		for i := 0; i < len(findings); i++ {
+			if findings[i].Fingerprint == "" {
+				findings[i].Fingerprint = findings[i].fingerprint()
+			}
+		}
+	}
+	return report, nil
+}
+
+// WriteReport writes the report in the given format to the given file, "-" means stdout
+func WriteReport(report *Report, path string, format string) error {
+	writer := reportWriters[format]
+	if writer == nil {
+		return fmt.Errorf("unknown report format %s", format)
+	}
+
+	if path == "-" {
+		return writer.Write(os.Stdout, report)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writer.Write(file, report)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
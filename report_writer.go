@@ -0,0 +1,223 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSON     string = "json"
+	FormatCSV      string = "csv"
+	FormatMarkdown string = "markdown"
+)
+
+// ReportWriter renders a report, or the diff of two reports, in one output format
+type ReportWriter interface {
+	Write(w io.Writer, report *Report) error
+	WriteDiff(w io.Writer, diff *ReportDiff) error
+}
+
+var reportWriters = map[string]ReportWriter{
+	FormatJSON:     jsonReportWriter{},
+	FormatCSV:      csvReportWriter{},
+	FormatMarkdown: markdownReportWriter{},
+}
+
+type jsonReportWriter struct{}
+
+func (jsonReportWriter) Write(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func (jsonReportWriter) WriteDiff(w io.Writer, diff *ReportDiff) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diff)
+}
+
+// csvReportWriter writes one row per finding
+type csvReportWriter struct{}
+
+func (csvReportWriter) Write(w io.Writer, report *Report) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"resource_type", "side", "neutron_id", "vsd_id", "external_id", "az", "code", "issue"})
+	if err != nil {
+		return err
+	}
+
+	for _, resourceType := range report.ResourceTypes() {
+		for _, finding := range report.Findings[resourceType] {
+			err = writer.Write([]string{finding.ResourceType, finding.Side, finding.NeutronID, finding.VsdID,
+				finding.ExternalID, finding.AZ, finding.Code, finding.Message})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func (csvReportWriter) WriteDiff(w io.Writer, diff *ReportDiff) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"status", "resource_type", "side", "neutron_id", "vsd_id", "external_id", "az", "code",
+		"fingerprint", "first_seen", "age", "issue"})
+	if err != nil {
+		return err
+	}
+
+	for _, findings := range [][]DiffFinding{diff.Added, diff.Removed, diff.Unchanged} {
+		for _, finding := range findings {
+			err = writer.Write([]string{finding.Status, finding.ResourceType, finding.Side, finding.NeutronID, finding.VsdID,
+				finding.ExternalID, finding.AZ, finding.Code, finding.Fingerprint, finding.FirstSeen.Format(time.RFC3339),
+				finding.Age(), finding.Message})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, resourceType := range diff.SkippedResourceTypes() {
+		err = writer.Write([]string{DiffStatusSkipped, resourceType, "", "", "", "", "", "", "", "", "", diff.Skipped[resourceType]})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// markdownReportWriter writes a summary table followed by one table per resource type
+type markdownReportWriter struct{}
+
+func markdownEscape(s string) string {
+	return strings.Replace(s, "|", "\\|", -1)
+}
+
+func (markdownReportWriter) Write(w io.Writer, report *Report) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# nuageresscan report\n\n")
+	fmt.Fprintf(&b, "- Version: %s\n", report.Version)
+	fmt.Fprintf(&b, "- Start: %s\n", report.StartTime.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- End: %s\n", report.EndTime.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- Neutron: %s\n", report.NeutronHost)
+	if report.Snapshot != "" {
+		fmt.Fprintf(&b, "- Snapshot: %s\n", report.Snapshot)
+	}
+	if report.Filter != nil {
+		fmt.Fprintf(&b, "- Filter: %s\n", markdownEscape(report.Filter.String()))
+	}
+	for _, vsd := range report.Vsds {
+		fmt.Fprintf(&b, "- VSD: %s (%s, %s)\n", vsd.URL, vsd.AZ, vsd.NetPartition)
+	}
+
+	fmt.Fprintf(&b, "\n## Summary\n\n")
+	fmt.Fprintf(&b, "| Resource type | Missing on neutron | Missing on nuage | Total | Suppressed |\n")
+	fmt.Fprintf(&b, "|---|---:|---:|---:|---:|\n")
+	for _, resourceType := range report.ResourceTypes() {
+		counts := make(map[string]int)
+		for _, finding := range report.Findings[resourceType] {
+			counts[finding.Side]++
+		}
+		fmt.Fprintf(&b, "| %s | %d | %d | %d | %d |\n", resourceType, counts[SideNeutron], counts[SideNuage],
+			len(report.Findings[resourceType]), report.Suppressed[resourceType])
+	}
+
+	if len(report.ExpiredSuppressions) > 0 {
+		fmt.Fprintf(&b, "\n## Expired suppressions\n\n")
+		fmt.Fprintf(&b, "| Expires | Fingerprint | Resource type | ID | Code | Reason |\n")
+		fmt.Fprintf(&b, "|---|---|---|---|---|---|\n")
+		for _, suppression := range report.ExpiredSuppressions {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", suppression.Expires, suppression.Fingerprint,
+				suppression.ResourceType, markdownEscape(suppression.ID), suppression.Code, markdownEscape(suppression.Reason))
+		}
+	}
+
+	for _, resourceType := range report.ResourceTypes() {
+		findings := report.Findings[resourceType]
+		if len(findings) <= 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", resourceType)
+		fmt.Fprintf(&b, "| Side | Neutron ID | VSD ID | External ID | AZ | Code | Issue |\n")
+		fmt.Fprintf(&b, "|---|---|---|---|---|---|---|\n")
+		for _, finding := range findings {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s |\n", finding.Side, finding.NeutronID, finding.VsdID,
+				markdownEscape(finding.ExternalID), finding.AZ, finding.Code, markdownEscape(finding.Message))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (markdownReportWriter) WriteDiff(w io.Writer, diff *ReportDiff) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# nuageresscan diff\n\n")
+	fmt.Fprintf(&b, "- Version: %s\n", diff.Version)
+	fmt.Fprintf(&b, "- Old: %s (%s)\n", diff.OldReport, diff.OldStartTime.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- New: %s (%s)\n", diff.NewReport, diff.NewStartTime.Format("2006-01-02 15:04:05 MST"))
+
+	fmt.Fprintf(&b, "\n## Summary\n\n")
+	fmt.Fprintf(&b, "| Added | Removed | Unchanged |\n")
+	fmt.Fprintf(&b, "|---:|---:|---:|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d |\n", len(diff.Added), len(diff.Removed), len(diff.Unchanged))
+
+	sections := []struct {
+		title    string
+		findings []DiffFinding
+	}{
+		{"Added", diff.Added},
+		{"Removed", diff.Removed},
+		{"Unchanged", diff.Unchanged},
+	}
+	for _, section := range sections {
+		if len(section.findings) <= 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", section.title)
+		fmt.Fprintf(&b, "| Resource type | Side | Neutron ID | VSD ID | AZ | Code | First seen | Age | Issue |\n")
+		fmt.Fprintf(&b, "|---|---|---|---|---|---|---|---:|---|\n")
+		for _, finding := range section.findings {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s | %s | %s |\n", finding.ResourceType, finding.Side,
+				finding.NeutronID, finding.VsdID, finding.AZ, finding.Code, finding.FirstSeen.Format("2006-01-02 15:04 MST"),
+				finding.Age(), markdownEscape(finding.Message))
+		}
+	}
+
+	if len(diff.Skipped) > 0 {
+		fmt.Fprintf(&b, "\n## Skipped\n\n")
+		fmt.Fprintf(&b, "| Resource type | Reason |\n")
+		fmt.Fprintf(&b, "|---|---|\n")
+		for _, resourceType := range diff.SkippedResourceTypes() {
+			fmt.Fprintf(&b, "| %s | %s |\n", resourceType, markdownEscape(diff.Skipped[resourceType]))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
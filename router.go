@@ -16,20 +16,40 @@
 package main
 
 import (
+	"fmt"
 	"github.com/nuagenetworks/vspk-go/vspk"
 	"github.com/sirupsen/logrus"
 	"strings"
 )
 
+// Finding codes
+const (
+	CodeRouterAzMissing         string = "router-az-missing"
+	CodeRouterCMSIDMissing      string = "router-cms-id-missing"
+	CodeDomainMissing           string = "domain-missing"
+	CodeDomainOrphan            string = "domain-orphan"
+	CodeDomainExternalIDEmpty   string = "domain-external-id-empty"
+	CodeDomainExternalIDInvalid string = "domain-external-id-invalid"
+)
+
 // Neutron resources
 var neutronRouters []Router
+var neutronRouterPorts []RouterPort
+var neutronNewarchAzRouterNuages []NewarchAzRouterNuage
 var neutronRouterMap map[string]*Router
+var neutronRouterPortsMap map[string][]*RouterPort
+var neutronNewarchAzRouterNuagesMap map[string][]*NewarchAzRouterNuage
 
 // Nuage resources
 var nuageDomains vspk.DomainsList
 var nuageDomainMap map[string]*vspk.Domain
+var nuageDomainVsdMap map[string]*VSD
 
 func dumpAllNeutronRouterResources() error {
+	neutronRouters = nil
+	neutronRouterPorts = nil
+	neutronNewarchAzRouterNuages = nil
+
 	logrus.WithField("func", "dumpAllNeutronRouterResources").
 		Info("SelectAllRouters")
 	err := SelectAllRouters(&neutronRouters)
@@ -42,31 +62,65 @@ func dumpAllNeutronRouterResources() error {
 		neutronRouterMap[router.ID] = router
 	}
 
+	logrus.WithField("func", "dumpAllNeutronRouterResources").
+		Info("SelectAllRouterPorts")
+	err = SelectAllRouterPorts(&neutronRouterPorts)
+	if err != nil {
+		return err
+	}
+	neutronRouterPortsMap = make(map[string][]*RouterPort)
+	for i := 0; i < len(neutronRouterPorts); i++ {
+		routerPort := &neutronRouterPorts[i]
+		neutronRouterPortsMap[routerPort.RouterID] = append(neutronRouterPortsMap[routerPort.RouterID], routerPort)
+	}
+
+	logrus.WithField("func", "dumpAllNeutronRouterResources").
+		Info("SelectAllNewarchAzRouterNuages")
+	err = SelectAllNewarchAzRouterNuages(&neutronNewarchAzRouterNuages)
+	if err != nil {
+		return err
+	}
+	neutronNewarchAzRouterNuagesMap = make(map[string][]*NewarchAzRouterNuage)
+	for i := 0; i < len(neutronNewarchAzRouterNuages); i++ {
+		newarchAzRouterNuage := &neutronNewarchAzRouterNuages[i]
+		if !newarchAzRouterNuage.RouterID.Valid {
+			continue
+		}
+		routerID := newarchAzRouterNuage.RouterID.String
+		neutronNewarchAzRouterNuagesMap[routerID] = append(neutronNewarchAzRouterNuagesMap[routerID], newarchAzRouterNuage)
+	}
+
 	return nil
 }
 
 func dumpAllNuageDomainResources() error {
+	nuageDomains = nil
 	nuageDomainMap = make(map[string]*vspk.Domain)
+	nuageDomainVsdMap = make(map[string]*VSD)
 
-	for _, vsd := range globalConfig.Vsds {
-		me, err := StartSession(vsd.Username, vsd.Password, vsd.Organization, vsd.URL)
-		if err != nil {
-			return err
-		}
+	// Fetch the VSDs in parallel, the loop below only reads the inventories
+	err := prefetchVsdInventories(func(inventory *VsdInventory) error {
+		_, err := inventory.Domains()
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-		enterprise, err := FetchEnterpriseByName(me, vsd.NetPartition)
+	for i := 0; i < len(globalConfig.Vsds); i++ {
+		vsd := &globalConfig.Vsds[i]
+		inventory, err := GetVsdInventory(vsd)
 		if err != nil {
 			return err
 		}
 
-		logrus.WithField("func", "dumpAllNuageDomainResources").
-			Info("FetchAllDomains from " + vsd.NetPartition)
-		domains, err := FetchAllDomains(enterprise)
+		domains, err := inventory.Domains()
 		if err != nil {
 			return err
 		}
 		nuageDomains = append(nuageDomains, domains...)
 		for _, domain := range domains {
+			nuageDomainVsdMap[domain.ID] = vsd
 			if domain.ExternalID == "" {
 				logrus.WithFields(logrus.Fields{"func": "dumpAllNuageDomainResources", "object": "nuage"}).
 					Warningf("found domain %s with empty externalID", domain.ID)
@@ -84,79 +138,127 @@ func dumpAllNuageDomainResources() error {
 	return nil
 }
 
-func scanResForRouterBaseOnNeutron() {
-	for _, neutronRouter := range neutronRouters {
-		var routerPorts []RouterPort
-		err := SelectRouterPortsByRouterID(&routerPorts, neutronRouter.ID)
-		if err != nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForRouterBaseOnNeutron", "object": "neutron"}).Error(err)
-		}
+// routerVsd returns the VSD of the router when it lives in a single AZ of the config
+func routerVsd(routerID string) *VSD {
+	newarchAzRouterNuages := neutronNewarchAzRouterNuagesMap[routerID]
+	if len(newarchAzRouterNuages) != 1 || !newarchAzRouterNuages[0].AzName.Valid {
+		return nil
+	}
+	return lookupVsd(func(config *Config) *VSD {
+		return GetVSDByAZ(config, newarchAzRouterNuages[0].AzName.String)
+	})
+}
 
-		if len(routerPorts) <= 0 {
+func scanResForRouterBaseOnNeutron() []Finding {
+	var findings []Finding
+	for _, neutronRouter := range neutronRouters {
+		if len(neutronRouterPortsMap[neutronRouter.ID]) <= 0 {
 			continue
 		}
 
-		var newarchAzRouterNuages []NewarchAzRouterNuage
-		err = SelectNewarchAzRouterNuagesByRouterID(&newarchAzRouterNuages, neutronRouter.ID)
-		for _, newarchAzRouterNuage := range newarchAzRouterNuages {
+		for _, newarchAzRouterNuage := range neutronNewarchAzRouterNuagesMap[neutronRouter.ID] {
 			if !newarchAzRouterNuage.AzName.Valid {
-				logrus.WithFields(logrus.Fields{"func": "scanResForRouterBaseOnNeutron", "object": "neutron"}).
-					Warning("az is null for router " + neutronRouter.ID)
+				findings = append(findings, Finding{
+					ResourceType: ResTypeRouter,
+					Side:         SideNeutron,
+					NeutronID:    neutronRouter.ID,
+					Code:         CodeRouterAzMissing,
+					Message:      "az is null for router " + neutronRouter.ID,
+				})
+				continue
+			}
+
+			if scanFilter.skipsAZ(newarchAzRouterNuage.AzName.String) {
 				continue
 			}
 
 			cmsID := GetCMSID(globalConfig, newarchAzRouterNuage.AzName.String)
 			if cmsID == "" {
-				logrus.WithFields(logrus.Fields{"func": "scanResForRouterBaseOnNeutron", "object": "neutron"}).
-					Warning("cms_id was not found for AZ" + newarchAzRouterNuage.AzName.String)
+				findings = append(findings, Finding{
+					ResourceType: ResTypeRouter,
+					Side:         SideNeutron,
+					NeutronID:    neutronRouter.ID,
+					AZ:           newarchAzRouterNuage.AzName.String,
+					Code:         CodeRouterCMSIDMissing,
+					Message:      "cms_id was not found for AZ" + newarchAzRouterNuage.AzName.String,
+				})
 				continue
 			}
 
 			externalID := neutronRouter.ID + "@" + cmsID
 			nuageDomain := nuageDomainMap[externalID]
 			if nuageDomain == nil {
-				logrus.WithFields(logrus.Fields{"func": "scanResForRouterBaseOnNeutron", "object": "nuage"}).
-					Warningf("domain %s was not found", externalID)
+				findings = append(findings, Finding{
+					ResourceType: ResTypeRouter,
+					Side:         SideNuage,
+					NeutronID:    neutronRouter.ID,
+					VsdID:        newarchAzRouterNuage.NuageRouterID.String,
+					ExternalID:   externalID,
+					Code:         CodeDomainMissing,
+					Message:      fmt.Sprintf("domain %s was not found", externalID),
+				}.withVsd(GetVSDByAZ(globalConfig, newarchAzRouterNuage.AzName.String)))
 			}
 		}
 	}
+
+	return findings
 }
 
-func scanResForRouterBaseOnNuage() {
+func scanResForRouterBaseOnNuage() []Finding {
+	var findings []Finding
 	for _, nuageDomain := range nuageDomains {
 		if nuageDomain.ExternalID == "" {
-			logrus.WithFields(logrus.Fields{"func": "scanResForRouterBaseOnNuage", "object": "nuage"}).
-				Warningf("found domain %s with empty externalID", nuageDomain.ID)
+			findings = append(findings, Finding{
+				ResourceType: ResTypeRouter,
+				Side:         SideNuage,
+				VsdID:        nuageDomain.ID,
+				Code:         CodeDomainExternalIDEmpty,
+				Message:      fmt.Sprintf("found domain %s with empty externalID", nuageDomain.ID),
+			}.withVsd(nuageDomainVsdMap[nuageDomain.ID]))
 			continue
 		}
 		neutronRouterID := strings.Split(nuageDomain.ExternalID, "@")[0]
 		if neutronRouterID == "" {
-			logrus.WithFields(logrus.Fields{"func": "scanResForRouterBaseOnNuage", "object": "nuage"}).
-				Warning("invalid domain externalID " + nuageDomain.ExternalID)
+			findings = append(findings, Finding{
+				ResourceType: ResTypeRouter,
+				Side:         SideNuage,
+				VsdID:        nuageDomain.ID,
+				ExternalID:   nuageDomain.ExternalID,
+				Code:         CodeDomainExternalIDInvalid,
+				Message:      "invalid domain externalID " + nuageDomain.ExternalID,
+			}.withVsd(nuageDomainVsdMap[nuageDomain.ID]))
 			continue
 		}
 		neutronRouter := neutronRouterMap[neutronRouterID]
 		if neutronRouter == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForRouterBaseOnNuage", "object": "neutron"}).
-				Warningf("router.id %s was not found", neutronRouterID)
+			findings = append(findings, Finding{
+				ResourceType: ResTypeRouter,
+				Side:         SideNeutron,
+				NeutronID:    neutronRouterID,
+				VsdID:        nuageDomain.ID,
+				ExternalID:   nuageDomain.ExternalID,
+				Code:         CodeDomainOrphan,
+				Message:      fmt.Sprintf("router.id %s was not found", neutronRouterID),
+			}.withVsd(nuageDomainVsdMap[nuageDomain.ID]))
 			continue
 		}
 	}
+
+	return findings
 }
 
-func scanResForRouter() {
+func scanResForRouter() ([]Finding, error) {
 	err := dumpAllNeutronRouterResources()
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"func": "dumpAllNeutronRouterResources", "object": "neutron"}).Error(err)
-		return
+		return nil, fmt.Errorf("dumpAllNeutronRouterResources: %s", err)
 	}
 
 	err = dumpAllNuageDomainResources()
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"func": "dumpAllNuageDomainResources", "object": "nuage"}).Error(err)
-		return
+		return nil, fmt.Errorf("dumpAllNuageDomainResources: %s", err)
 	}
 
-	scanResForRouterBaseOnNeutron()
-	scanResForRouterBaseOnNuage()
+	findings := scanResForRouterBaseOnNeutron()
+	findings = append(findings, scanResForRouterBaseOnNuage()...)
+	return findings, nil
 }
@@ -0,0 +1,240 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Number of routers of the synthetic dataset
+const benchmarkRouters = 1000
+
+// Round-trip time of a query to the Neutron database
+const benchmarkQueryLatency = 100 * time.Microsecond
+
+// syntheticRouterSnapshot returns routers with two ports each and one AZ mapping
+// each, spread over the two test VSDs
+func syntheticRouterSnapshot(routers int) *Snapshot {
+	snapshot := &Snapshot{Config: Config{Vsds: testVsds}}
+	for i := 0; i < routers; i++ {
+		routerID := fmt.Sprintf("router-%d", i)
+		snapshot.Neutron.Routers = append(snapshot.Neutron.Routers, Router{ID: routerID})
+		for j := 0; j < 2; j++ {
+			snapshot.Neutron.RouterPorts = append(snapshot.Neutron.RouterPorts,
+				RouterPort{RouterID: routerID, PortID: fmt.Sprintf("port-%d-%d", i, j)})
+		}
+		snapshot.Neutron.NewarchAzRouterNuages = append(snapshot.Neutron.NewarchAzRouterNuages, NewarchAzRouterNuage{
+			RouterID:      nullString(routerID),
+			AzName:        nullString(testVsds[i%len(testVsds)].AZ),
+			NuageRouterID: nullString(fmt.Sprintf("domain-%d", i)),
+		})
+	}
+	return snapshot
+}
+
+// benchmarkDB is a database answering the queries "select <columns> from <table>"
+// with an optional "where <column> in (?, ...)" from the tables of a snapshot. It
+// counts the queries and waits the round-trip time before answering each one.
+type benchmarkDB struct {
+	tables  map[string]interface{}
+	queries int64
+}
+
+var benchmarkQueryRegexp = regexp.MustCompile(`^select (.+) from (\w+)(?: where (\w+) in \(([?, ]+)\))?$`)
+
+// openBenchmarkDB makes the Select functions query the tables of the snapshot
+// through the database/sql driver for the duration of the benchmark
+func openBenchmarkDB(b *testing.B, snapshot *NeutronSnapshot) *benchmarkDB {
+	db := &benchmarkDB{tables: map[string]interface{}{
+		"routers":                 snapshot.Routers,
+		"routerports":             snapshot.RouterPorts,
+		"newarch_az_router_nuage": snapshot.NewarchAzRouterNuages,
+	}}
+	previousDB := DB
+	DB = sqlx.NewDb(sql.OpenDB(db), "mysql")
+	neutronSnapshot = nil
+	scanFilter = nil
+	b.Cleanup(func() {
+		DB.Close()
+		DB = previousDB
+		scanFilter = nil
+	})
+	return db
+}
+
+func (db *benchmarkDB) Open(name string) (driver.Conn, error) {
+	return &benchmarkConn{db: db}, nil
+}
+
+func (db *benchmarkDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return db.Open("")
+}
+
+func (db *benchmarkDB) Driver() driver.Driver {
+	return db
+}
+
+type benchmarkConn struct {
+	db *benchmarkDB
+}
+
+func (c *benchmarkConn) Prepare(query string) (driver.Stmt, error) {
+	return &benchmarkStmt{db: c.db, query: query}, nil
+}
+
+func (c *benchmarkConn) Close() error {
+	return nil
+}
+
+func (c *benchmarkConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+type benchmarkStmt struct {
+	db    *benchmarkDB
+	query string
+}
+
+func (s *benchmarkStmt) Close() error {
+	return nil
+}
+
+func (s *benchmarkStmt) NumInput() int {
+	return -1
+}
+
+func (s *benchmarkStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("%s is not a query", s.query)
+}
+
+func (s *benchmarkStmt) Query(args []driver.Value) (driver.Rows, error) {
+	atomic.AddInt64(&s.db.queries, 1)
+	time.Sleep(benchmarkQueryLatency)
+
+	match := benchmarkQueryRegexp.FindStringSubmatch(s.query)
+	if match == nil {
+		return nil, fmt.Errorf("unsupported query %s", s.query)
+	}
+	table, ok := s.db.tables[match[2]]
+	if !ok {
+		return nil, fmt.Errorf("unknown table %s", match[2])
+	}
+	values := make(map[string]bool)
+	for _, arg := range args {
+		values[fmt.Sprint(arg)] = true
+	}
+
+	rows := &benchmarkRows{columns: strings.Split(match[1], ", ")}
+	tableRows := reflect.ValueOf(table)
+	for i := 0; i < tableRows.Len(); i++ {
+		row := tableRows.Index(i)
+		if match[3] != "" && !values[rowColumn(row, match[3])] {
+			continue
+		}
+		var rowValues []driver.Value
+		for _, column := range rows.columns {
+			rowValues = append(rowValues, rowColumn(row, column))
+		}
+		rows.values = append(rows.values, rowValues)
+	}
+	return rows, nil
+}
+
+type benchmarkRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *benchmarkRows) Columns() []string {
+	return r.columns
+}
+
+func (r *benchmarkRows) Close() error {
+	return nil
+}
+
+func (r *benchmarkRows) Next(dest []driver.Value) error {
+	if len(r.values) <= 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// BenchmarkRouterLoadingPerRouter loads the router ports and AZ mappings with
+// two queries per router, as the scan did before loading them in bulk
+func BenchmarkRouterLoadingPerRouter(b *testing.B) {
+	db := openBenchmarkDB(b, &syntheticRouterSnapshot(benchmarkRouters).Neutron)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		var routers []Router
+		err := SelectAllRouters(&routers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, router := range routers {
+			// Same rows as "where router_id=?"
+			scanFilter = &ScanFilter{ids: map[string]bool{router.ID: true}}
+			var routerPorts []RouterPort
+			var newarchAzRouterNuages []NewarchAzRouterNuage
+			err = SelectAllRouterPorts(&routerPorts)
+			if err == nil {
+				err = SelectAllNewarchAzRouterNuages(&newarchAzRouterNuages)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(routerPorts) <= 0 || len(newarchAzRouterNuages) <= 0 {
+				b.Fatalf("router %s has no rows", router.ID)
+			}
+		}
+		scanFilter = nil
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&db.queries))/float64(b.N), "queries/op")
+}
+
+// BenchmarkRouterLoadingBulk loads all router ports and AZ mappings at once and
+// looks them up per router in the maps
+func BenchmarkRouterLoadingBulk(b *testing.B) {
+	db := openBenchmarkDB(b, &syntheticRouterSnapshot(benchmarkRouters).Neutron)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		err := dumpAllNeutronRouterResources()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, router := range neutronRouters {
+			if len(neutronRouterPortsMap[router.ID]) <= 0 || len(neutronNewarchAzRouterNuagesMap[router.ID]) <= 0 {
+				b.Fatalf("router %s was not indexed", router.ID)
+			}
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&db.queries))/float64(b.N), "queries/op")
+}
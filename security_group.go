@@ -15,5 +15,335 @@
 
 package main
 
-func scanResForSecurityGroup() {
+import (
+	"fmt"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Finding codes
+const (
+	CodePolicyGroupMissing           string = "policygroup-missing"
+	CodePolicyGroupOrphan            string = "policygroup-orphan"
+	CodePolicyGroupExternalIDInvalid string = "policygroup-external-id-invalid"
+	CodeIngressACLEntryOrphan        string = "ingress-acl-entry-orphan"
+	CodeEgressACLEntryOrphan         string = "egress-acl-entry-orphan"
+)
+
+// Neutron resources
+var neutronSecurityGroups []SecurityGroup
+var neutronSecurityGroupRules []SecurityGroupRule
+var neutronSecurityGroupPortBindings []SecurityGroupPortBinding
+var neutronSecurityGroupMap map[string]*SecurityGroup
+var neutronSecurityGroupRuleMap map[string]*SecurityGroupRule
+var neutronSecurityGroupPortIDsMap map[string][]string
+
+// Nuage resources
+var nuagePolicyGroups vspk.PolicyGroupsList
+var nuageIngressACLEntryTemplates vspk.IngressACLEntryTemplatesList
+var nuageEgressACLEntryTemplates vspk.EgressACLEntryTemplatesList
+var nuagePolicyGroupMap map[string]*vspk.PolicyGroup
+var nuagePolicyGroupIDMap map[string]*vspk.PolicyGroup
+var nuagePolicyGroupVsdMap map[string]*VSD
+var nuageACLEntryVsdMap map[string]*VSD
+
+func dumpAllNeutronSecurityGroupResources() error {
+	neutronSecurityGroups = nil
+	neutronSecurityGroupRules = nil
+	neutronSecurityGroupPortBindings = nil
+
+	logrus.WithField("func", "dumpAllNeutronSecurityGroupResources").
+		Info("SelectAllSecurityGroups")
+	err := SelectAllSecurityGroups(&neutronSecurityGroups)
+	if err != nil {
+		return err
+	}
+	neutronSecurityGroupMap = make(map[string]*SecurityGroup)
+	for i := 0; i < len(neutronSecurityGroups); i++ {
+		securityGroup := &neutronSecurityGroups[i]
+		neutronSecurityGroupMap[securityGroup.ID] = securityGroup
+	}
+
+	logrus.WithField("func", "dumpAllNeutronSecurityGroupResources").
+		Info("SelectAllSecurityGroupRules")
+	err = SelectAllSecurityGroupRules(&neutronSecurityGroupRules)
+	if err != nil {
+		return err
+	}
+	neutronSecurityGroupRuleMap = make(map[string]*SecurityGroupRule)
+	for i := 0; i < len(neutronSecurityGroupRules); i++ {
+		securityGroupRule := &neutronSecurityGroupRules[i]
+		neutronSecurityGroupRuleMap[securityGroupRule.ID] = securityGroupRule
+	}
+
+	logrus.WithField("func", "dumpAllNeutronSecurityGroupResources").
+		Info("SelectAllSecurityGroupPortBindings")
+	err = SelectAllSecurityGroupPortBindings(&neutronSecurityGroupPortBindings)
+	if err != nil {
+		return err
+	}
+	neutronSecurityGroupPortIDsMap = make(map[string][]string)
+	for _, binding := range neutronSecurityGroupPortBindings {
+		neutronSecurityGroupPortIDsMap[binding.SecurityGroupID] = append(neutronSecurityGroupPortIDsMap[binding.SecurityGroupID], binding.PortID)
+	}
+
+	// Policy groups are only created for security groups in use by nuage ports
+	return dumpAllNeutronPortResources()
+}
+
+func dumpAllNuagePolicyGroupResources() error {
+	nuagePolicyGroups = nil
+	nuageIngressACLEntryTemplates = nil
+	nuageEgressACLEntryTemplates = nil
+	nuagePolicyGroupMap = make(map[string]*vspk.PolicyGroup)
+	nuagePolicyGroupIDMap = make(map[string]*vspk.PolicyGroup)
+	nuagePolicyGroupVsdMap = make(map[string]*VSD)
+	nuageACLEntryVsdMap = make(map[string]*VSD)
+
+	// Fetch the VSDs in parallel, the loop below only reads the inventories
+	err := prefetchVsdInventories(func(inventory *VsdInventory) error {
+		_, err := inventory.PolicyGroups()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.IngressACLEntryTemplates()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.EgressACLEntryTemplates()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(globalConfig.Vsds); i++ {
+		vsd := &globalConfig.Vsds[i]
+		inventory, err := GetVsdInventory(vsd)
+		if err != nil {
+			return err
+		}
+
+		policyGroups, err := inventory.PolicyGroups()
+		if err != nil {
+			return err
+		}
+		nuagePolicyGroups = append(nuagePolicyGroups, policyGroups...)
+		for _, policyGroup := range policyGroups {
+			nuagePolicyGroupVsdMap[policyGroup.ID] = vsd
+		}
+
+		ingressACLEntryTemplates, err := inventory.IngressACLEntryTemplates()
+		if err != nil {
+			return err
+		}
+		nuageIngressACLEntryTemplates = append(nuageIngressACLEntryTemplates, ingressACLEntryTemplates...)
+		for _, entry := range ingressACLEntryTemplates {
+			nuageACLEntryVsdMap[entry.ID] = vsd
+		}
+
+		egressACLEntryTemplates, err := inventory.EgressACLEntryTemplates()
+		if err != nil {
+			return err
+		}
+		nuageEgressACLEntryTemplates = append(nuageEgressACLEntryTemplates, egressACLEntryTemplates...)
+		for _, entry := range egressACLEntryTemplates {
+			nuageACLEntryVsdMap[entry.ID] = vsd
+		}
+	}
+
+	for _, policyGroup := range nuagePolicyGroups {
+		nuagePolicyGroupIDMap[policyGroup.ID] = policyGroup
+		if policyGroup.ExternalID == "" {
+			continue
+		}
+		// The same security group has one policy group per domain, keep the first one
+		if nuagePolicyGroupMap[policyGroup.ExternalID] == nil {
+			nuagePolicyGroupMap[policyGroup.ExternalID] = policyGroup
+		}
+	}
+
+	return nil
+}
+
+// isNuageSecurityGroup tells whether the security group is used by at least one nuage port
+func isNuageSecurityGroup(securityGroup *SecurityGroup) bool {
+	for _, portID := range neutronSecurityGroupPortIDsMap[securityGroup.ID] {
+		port := neutronPortMap[portID]
+		if port != nil && isNuagePort(port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// securityGroupVsds returns the known VSDs of the nuage ports of the security group,
+// including the VSDs left out by the scan filter
+func securityGroupVsds(securityGroup *SecurityGroup) []*VSD {
+	var vsds []*VSD
+	azs := make(map[string]bool)
+	for _, portID := range neutronSecurityGroupPortIDsMap[securityGroup.ID] {
+		port := neutronPortMap[portID]
+		if port == nil || !isNuagePort(port) {
+			continue
+		}
+		vsd := portVsd(port)
+		if vsd != nil && !azs[vsd.AZ] {
+			azs[vsd.AZ] = true
+			vsds = append(vsds, vsd)
+		}
+	}
+	return vsds
+}
+
+// isNuageACLEntry tells whether the acl entry was created by the nuage plugin for a security group rule
+func isNuageACLEntry(externalID string, locationType string, locationID string) bool {
+	if externalID == "" || locationType != "POLICYGROUP" {
+		return false
+	}
+
+	policyGroup := nuagePolicyGroupIDMap[locationID]
+	return policyGroup != nil && policyGroup.ExternalID != ""
+}
+
+func scanResForSecurityGroupBaseOnNeutron() []Finding {
+	var findings []Finding
+	for i := 0; i < len(neutronSecurityGroups); i++ {
+		neutronSecurityGroup := &neutronSecurityGroups[i]
+		if !isNuageSecurityGroup(neutronSecurityGroup) {
+			continue
+		}
+
+		// A policy group is expected on the VSD of every nuage port of the
+		// security group, or on any VSD when none of them is known
+		vsds := securityGroupVsds(neutronSecurityGroup)
+		if len(vsds) <= 0 {
+			var nuagePolicyGroup *vspk.PolicyGroup
+			for _, vsd := range globalConfig.Vsds {
+				nuagePolicyGroup = nuagePolicyGroupMap[neutronSecurityGroup.ID+"@"+vsd.CMSID]
+				if nuagePolicyGroup != nil {
+					break
+				}
+			}
+			if nuagePolicyGroup == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSecuritygroup,
+					Side:         SideNuage,
+					NeutronID:    neutronSecurityGroup.ID,
+					Code:         CodePolicyGroupMissing,
+					Message:      fmt.Sprintf("policy group of security group %s was not found", neutronSecurityGroup.ID),
+				})
+			}
+			continue
+		}
+
+		for _, vsd := range vsds {
+			if scanFilter.skipsVsd(vsd) {
+				continue
+			}
+			externalID := neutronSecurityGroup.ID + "@" + vsd.CMSID
+			nuagePolicyGroup := nuagePolicyGroupMap[externalID]
+			if nuagePolicyGroup == nil || nuagePolicyGroupVsdMap[nuagePolicyGroup.ID] != vsd {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSecuritygroup,
+					Side:         SideNuage,
+					NeutronID:    neutronSecurityGroup.ID,
+					ExternalID:   externalID,
+					Code:         CodePolicyGroupMissing,
+					Message:      fmt.Sprintf("policy group %s was not found", externalID),
+				}.withVsd(vsd))
+			}
+		}
+	}
+
+	return findings
+}
+
+func scanResForSecurityGroupBaseOnNuage() []Finding {
+	var findings []Finding
+	for _, nuagePolicyGroup := range nuagePolicyGroups {
+		if nuagePolicyGroup.ExternalID == "" {
+			continue
+		}
+		neutronSecurityGroupID := strings.Split(nuagePolicyGroup.ExternalID, "@")[0]
+		if neutronSecurityGroupID == "" {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeSecuritygroup,
+				Side:         SideNuage,
+				VsdID:        nuagePolicyGroup.ID,
+				ExternalID:   nuagePolicyGroup.ExternalID,
+				Code:         CodePolicyGroupExternalIDInvalid,
+				Message:      "invalid policy group externalID " + nuagePolicyGroup.ExternalID,
+			}.withVsd(nuagePolicyGroupVsdMap[nuagePolicyGroup.ID]))
+			continue
+		}
+		neutronSecurityGroup := neutronSecurityGroupMap[neutronSecurityGroupID]
+		if neutronSecurityGroup == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeSecuritygroup,
+				Side:         SideNeutron,
+				NeutronID:    neutronSecurityGroupID,
+				VsdID:        nuagePolicyGroup.ID,
+				ExternalID:   nuagePolicyGroup.ExternalID,
+				Code:         CodePolicyGroupOrphan,
+				Message:      fmt.Sprintf("securitygroups.id %s of policy group %s was not found", neutronSecurityGroupID, nuagePolicyGroup.ID),
+			}.withVsd(nuagePolicyGroupVsdMap[nuagePolicyGroup.ID]))
+		}
+	}
+
+	for _, entry := range nuageIngressACLEntryTemplates {
+		if !isNuageACLEntry(entry.ExternalID, entry.LocationType, entry.LocationID) {
+			continue
+		}
+		neutronSecurityGroupRuleID := strings.Split(entry.ExternalID, "@")[0]
+		if neutronSecurityGroupRuleMap[neutronSecurityGroupRuleID] == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeSecuritygroup,
+				Side:         SideNeutron,
+				NeutronID:    neutronSecurityGroupRuleID,
+				VsdID:        entry.ID,
+				ExternalID:   entry.ExternalID,
+				Code:         CodeIngressACLEntryOrphan,
+				Message:      fmt.Sprintf("securitygrouprules.id %s of ingress acl entry %s was not found", neutronSecurityGroupRuleID, entry.ID),
+			}.withVsd(nuageACLEntryVsdMap[entry.ID]))
+		}
+	}
+
+	for _, entry := range nuageEgressACLEntryTemplates {
+		if !isNuageACLEntry(entry.ExternalID, entry.LocationType, entry.LocationID) {
+			continue
+		}
+		neutronSecurityGroupRuleID := strings.Split(entry.ExternalID, "@")[0]
+		if neutronSecurityGroupRuleMap[neutronSecurityGroupRuleID] == nil {
+			findings = append(findings, Finding{
+				ResourceType: ResTypeSecuritygroup,
+				Side:         SideNeutron,
+				NeutronID:    neutronSecurityGroupRuleID,
+				VsdID:        entry.ID,
+				ExternalID:   entry.ExternalID,
+				Code:         CodeEgressACLEntryOrphan,
+				Message:      fmt.Sprintf("securitygrouprules.id %s of egress acl entry %s was not found", neutronSecurityGroupRuleID, entry.ID),
+			}.withVsd(nuageACLEntryVsdMap[entry.ID]))
+		}
+	}
+
+	return findings
+}
+
+func scanResForSecurityGroup() ([]Finding, error) {
+	err := dumpAllNeutronSecurityGroupResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNeutronSecurityGroupResources: %s", err)
+	}
+
+	err = dumpAllNuagePolicyGroupResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNuagePolicyGroupResources: %s", err)
+	}
+
+	findings := scanResForSecurityGroupBaseOnNeutron()
+	findings = append(findings, scanResForSecurityGroupBaseOnNuage()...)
+	return findings, nil
 }
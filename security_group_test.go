@@ -0,0 +1,104 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"reflect"
+	"testing"
+)
+
+func TestScanResForSecurityGroup(t *testing.T) {
+	// sg1 is used by a port of each AZ, sg2 by no port
+	neutron := NeutronSnapshot{
+		Subnets: []Subnet{{ID: "s1"}, {ID: "s2"}},
+		NuageSubnetL2domMappings: []NuageSubnetL2domMapping{
+			{SubnetID: "s1", NuageSubnetID: "l1", NetPartitionName: nullString("OpenStack_bj")},
+			{SubnetID: "s2", NuageSubnetID: "l2", NetPartitionName: nullString("OpenStack_cs")},
+		},
+		Ports:                     []Port{testNuagePort("p1"), testNuagePort("p2")},
+		IPAllocations:             []IPAllocation{{PortID: "p1", SubnetID: "s1"}, {PortID: "p2", SubnetID: "s2"}},
+		SecurityGroups:            []SecurityGroup{{ID: "sg1"}, {ID: "sg2"}},
+		SecurityGroupRules:        []SecurityGroupRule{{ID: "r1", SecurityGroupID: "sg1"}},
+		SecurityGroupPortBindings: []SecurityGroupPortBinding{{PortID: "p1", SecurityGroupID: "sg1"}, {PortID: "p2", SecurityGroupID: "sg1"}},
+	}
+	pgBj := &vspk.PolicyGroup{ID: "pg-bj", ExternalID: "sg1@cms-bj"}
+	pgCs := &vspk.PolicyGroup{ID: "pg-cs", ExternalID: "sg1@cms-cs"}
+	pgVsd := &vspk.PolicyGroup{ID: "pg-vsd"}
+
+	for _, test := range []struct {
+		name     string
+		bj       VsdSnapshot
+		cs       VsdSnapshot
+		expected []string
+	}{
+		{
+			name: "in sync",
+			bj: VsdSnapshot{
+				PolicyGroups:             vspk.PolicyGroupsList{pgBj},
+				IngressACLEntryTemplates: vspk.IngressACLEntryTemplatesList{{ID: "i1", ExternalID: "r1@cms-bj", LocationType: "POLICYGROUP", LocationID: "pg-bj"}},
+			},
+			cs:       VsdSnapshot{PolicyGroups: vspk.PolicyGroupsList{pgCs}},
+			expected: []string{},
+		},
+		{
+			name:     "missing on one VSD",
+			bj:       VsdSnapshot{PolicyGroups: vspk.PolicyGroupsList{pgBj}},
+			expected: []string{"policygroup-missing sg1  cs"},
+		},
+		{
+			name: "orphan",
+			bj: VsdSnapshot{
+				PolicyGroups: vspk.PolicyGroupsList{pgBj, pgVsd, {ID: "pg-9", ExternalID: "sg9@cms-bj"}},
+				IngressACLEntryTemplates: vspk.IngressACLEntryTemplatesList{
+					{ID: "i9", ExternalID: "r9@cms-bj", LocationType: "POLICYGROUP", LocationID: "pg-bj"},
+					{ID: "i-any", ExternalID: "r9@cms-bj", LocationType: "ANY"},
+					{ID: "i-vsd", ExternalID: "r9@cms-bj", LocationType: "POLICYGROUP", LocationID: "pg-vsd"},
+				},
+				EgressACLEntryTemplates: vspk.EgressACLEntryTemplatesList{
+					{ID: "e9", ExternalID: "r9@cms-bj", LocationType: "POLICYGROUP", LocationID: "pg-bj"},
+					{ID: "e-none", LocationType: "POLICYGROUP", LocationID: "pg-bj"},
+				},
+			},
+			cs: VsdSnapshot{PolicyGroups: vspk.PolicyGroupsList{pgCs}},
+			expected: []string{
+				"egress-acl-entry-orphan r9 e9 bj",
+				"ingress-acl-entry-orphan r9 i9 bj",
+				"policygroup-orphan sg9 pg-9 bj",
+			},
+		},
+		{
+			name:     "external ID invalid",
+			bj:       VsdSnapshot{PolicyGroups: vspk.PolicyGroupsList{pgBj, {ID: "pg-x", ExternalID: "@cms-bj"}}},
+			cs:       VsdSnapshot{PolicyGroups: vspk.PolicyGroupsList{pgCs}},
+			expected: []string{"policygroup-external-id-invalid  pg-x bj"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.bj.AZ = "bj"
+			test.cs.AZ = "cs"
+			loadTestSnapshot(t, &Snapshot{Config: Config{Vsds: testVsds}, Neutron: neutron, Vsds: []VsdSnapshot{test.bj, test.cs}})
+
+			findings, err := scanResForSecurityGroup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := findingKeys(findings); !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("findings %q, expected %q", keys, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State of a scan of serve
+const (
+	ScanStateRunning string = "running"
+	ScanStateDone    string = "done"
+	ScanStateFailed  string = "failed"
+)
+
+// Trigger of a scan of serve
+const (
+	ScanTriggerSchedule string = "schedule"
+	ScanTriggerAPI      string = "api"
+)
+
+// ScanStatus is the status of one scan of serve
+type ScanStatus struct {
+	ID            string     `json:"id"`
+	ResourceTypes []string   `json:"resource_types"`
+	Trigger       string     `json:"trigger,omitempty"`
+	State         string     `json:"state"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       *time.Time `json:"end_time,omitempty"`
+	Findings      int        `json:"findings"`
+	Error         string     `json:"error,omitempty"`
+
+	report *Report
+}
+
+// Timeouts of the connections to the API of serve, a report may be long to write
+const (
+	serveReadHeaderTimeout = 10 * time.Second
+	serveReadTimeout       = 30 * time.Second
+	serveWriteTimeout      = 5 * time.Minute
+	serveIdleTimeout       = 2 * time.Minute
+)
+
+// Server runs the scans of serve one at a time, as the scanners share global state,
+// and keeps the last scans in memory and in the history directory. The handlers only
+// read the scan statuses and their reports under mutex, never the global state of
+// the scanners, which startJob guards with jobMutex.
+type Server struct {
+	configPath  string
+	options     *JobOptions
+	serveConfig ServeConfig
+	interval    time.Duration
+
+	mutex    sync.Mutex
+	current  *ScanStatus
+	history  []*ScanStatus
+	nextScan time.Time
+}
+
+func newScanID(startTime time.Time) string {
+	return startTime.UTC().Format("20060102T150405.000Z")
+}
+
+func historyReportPath(dir string, id string) string {
+	return filepath.Join(dir, "report-"+id+".json")
+}
+
+// loadHistory reads the reports of the previous runs of serve from the history directory
+func (s *Server) loadHistory() error {
+	if s.serveConfig.HistoryDir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.serveConfig.HistoryDir, "report-*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		report, err := ReadReport(path)
+		if err != nil {
+			logrus.WithField("func", "Server.loadHistory").Warning(err)
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "report-"), ".json")
+		endTime := report.EndTime
+		status := &ScanStatus{
+			ID:            id,
+			ResourceTypes: report.ResourceTypes(),
+			State:         ScanStateDone,
+			StartTime:     report.StartTime,
+			EndTime:       &endTime,
+			Findings:      report.FindingCount(),
+			report:        report,
+		}
+		if len(report.Errors) > 0 {
+			status.State = ScanStateFailed
+		}
+		s.history = append(s.history, status)
+	}
+	s.pruneHistory()
+	return nil
+}
+
+// pruneHistory drops the oldest scans beyond the history size, the caller holds the mutex
+func (s *Server) pruneHistory() {
+	for len(s.history) > s.serveConfig.History {
+		if s.serveConfig.HistoryDir != "" {
+			os.Remove(historyReportPath(s.serveConfig.HistoryDir, s.history[0].ID))
+		}
+		s.history = s.history[1:]
+	}
+}
+
+// lastReport returns the report of the last scan which produced one, the caller holds the mutex
+func (s *Server) lastReport() *Report {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].report != nil {
+			return s.history[i].report
+		}
+	}
+	return nil
+}
+
+func checkResourceTypes(resourceTypes []string) error {
+	for _, resourceType := range resourceTypes {
+		if !isResourceType(resourceType) {
+			return fmt.Errorf("unknown resource type %s", resourceType)
+		}
+	}
+	return nil
+}
+
+// Trigger starts a scan of the resource types, it fails when a scan is already running
+func (s *Server) Trigger(resourceTypes []string, trigger string) (*ScanStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.current != nil {
+		return nil, fmt.Errorf("scan %s is running", s.current.ID)
+	}
+
+	startTime := time.Now()
+	id := newScanID(startTime)
+	if len(s.history) > 0 && s.history[len(s.history)-1].ID >= id {
+		// The scan IDs are the names of the history reports, they have to be unique
+		id = newScanID(s.history[len(s.history)-1].StartTime.Add(time.Millisecond))
+	}
+	status := &ScanStatus{
+		ID:            id,
+		ResourceTypes: resourceTypes,
+		Trigger:       trigger,
+		State:         ScanStateRunning,
+		StartTime:     startTime,
+	}
+	s.current = status
+	statusCopy := *status
+
+	options := *s.options
+	options.PreviousReport = s.lastReport()
+	if options.PreviousReport != nil {
+		options.Previous = ""
+	}
+	go s.runScan(status, &options)
+
+	return &statusCopy, nil
+}
+
+func (s *Server) runScan(status *ScanStatus, options *JobOptions) {
+	logrus.WithField("func", "Server.runScan").
+		Infof("Start scan %s of %s", status.ID, strings.Join(status.ResourceTypes, ","))
+	report, err := startJob(s.configPath, strings.Join(status.ResourceTypes, ","), options)
+	if err != nil {
+		logrus.WithField("func", "Server.runScan").Error(err)
+	}
+
+	if report != nil {
+		SetMetricsReport(report)
+		if options.MetricsTextfile != "" {
+			metricsErr := WriteMetricsTextfile(report, options.MetricsTextfile)
+			if metricsErr != nil {
+				logrus.WithField("func", "Server.runScan").Error("failed to write metrics: ", metricsErr)
+			}
+		}
+		if s.serveConfig.HistoryDir != "" {
+			historyErr := WriteReport(report, historyReportPath(s.serveConfig.HistoryDir, status.ID), FormatJSON)
+			if historyErr != nil {
+				logrus.WithField("func", "Server.runScan").Error("failed to write history: ", historyErr)
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	endTime := time.Now()
+	status.EndTime = &endTime
+	status.State = ScanStateDone
+	if err != nil {
+		status.State = ScanStateFailed
+		status.Error = err.Error()
+	}
+	if report != nil {
+		status.Findings = report.FindingCount()
+		status.report = report
+	}
+	s.current = nil
+	s.history = append(s.history, status)
+	s.pruneHistory()
+}
+
+// schedule triggers a scan of the configured resource types every interval
+func (s *Server) schedule() {
+	for {
+		s.mutex.Lock()
+		s.nextScan = time.Now().Add(s.interval)
+		s.mutex.Unlock()
+
+		_, err := s.Trigger(s.serveConfig.ResourceTypes, ScanTriggerSchedule)
+		if err != nil {
+			logrus.WithField("func", "Server.schedule").Warning("skip scheduled scan: ", err)
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+// findScan returns the scan of the ID, latest is the last finished scan
+func (s *Server) findScan(id string) *ScanStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.current != nil && s.current.ID == id {
+		status := *s.current
+		return &status
+	}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].ID == id || id == "latest" {
+			status := *s.history[i]
+			return &status
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// filterReport returns a copy of the report with the findings of the resource type and AZ only,
+// an empty resource type or AZ matches all of them
+func filterReport(report *Report, resourceType string, az string) *Report {
+	filtered := *report
+	filtered.Findings = make(map[string][]Finding)
+	for rt, findings := range report.Findings {
+		if resourceType != "" && rt != resourceType {
+			continue
+		}
+		filtered.Findings[rt] = []Finding{}
+		for _, finding := range findings {
+			if az == "" || finding.AZ == az {
+				filtered.Findings[rt] = append(filtered.Findings[rt], finding)
+			}
+		}
+	}
+	return &filtered
+}
+
+// handleStatus serves GET /api/status
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	response := struct {
+		State    string      `json:"state"`
+		Current  *ScanStatus `json:"current,omitempty"`
+		Last     *ScanStatus `json:"last,omitempty"`
+		NextScan time.Time   `json:"next_scan"`
+	}{State: "idle", NextScan: s.nextScan}
+	if s.current != nil {
+		response.State = ScanStateRunning
+		response.Current = s.current
+	}
+	if len(s.history) > 0 {
+		response.Last = s.history[len(s.history)-1]
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleScans serves GET /api/scans, the scans newest first, and POST /api/scans which
+// starts a scan of the resource types of the resource_type query parameter, separated
+// by commas, or of the configured ones
+func (s *Server) handleScans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mutex.Lock()
+		scans := []*ScanStatus{}
+		if s.current != nil {
+			scans = append(scans, s.current)
+		}
+		for i := len(s.history) - 1; i >= 0; i-- {
+			scans = append(scans, s.history[i])
+		}
+		data, _ := json.MarshalIndent(scans, "", "  ")
+		s.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(data, '\n'))
+	case http.MethodPost:
+		resourceTypes := s.serveConfig.ResourceTypes
+		if r.URL.Query().Get("resource_type") != "" {
+			resourceTypes = strings.Split(r.URL.Query().Get("resource_type"), ",")
+		}
+		err := checkResourceTypes(resourceTypes)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		status, err := s.Trigger(resourceTypes, ScanTriggerAPI)
+		if err != nil {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, status)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+// handleScan serves GET /api/scans/<id> and GET /api/scans/<id>/report, the report
+// may be filtered with the resource_type and az query parameters
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/scans/")
+	id := strings.TrimSuffix(path, "/report")
+	status := s.findScan(id)
+	if status == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("scan %s was not found", id))
+		return
+	}
+	if id == path {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	if status.report == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("scan %s has no report", status.ID))
+		return
+	}
+	report := filterReport(status.report, r.URL.Query().Get("resource_type"), r.URL.Query().Get("az"))
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+	writer := reportWriters[format]
+	if writer == nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown report format %s", format))
+		return
+	}
+	writer.Write(w, report)
+}
+
+// startServe scans on the schedule of the serve section of the config and serves the API
+func startServe(configPath string, options *JobOptions) error {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+	serveConfig, interval, err := GetServeConfig(config)
+	if err != nil {
+		return err
+	}
+	err = checkResourceTypes(serveConfig.ResourceTypes)
+	if err != nil {
+		return err
+	}
+
+	server := &Server{configPath: configPath, options: options, serveConfig: serveConfig, interval: interval}
+	if serveConfig.HistoryDir != "" {
+		err = os.MkdirAll(serveConfig.HistoryDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err = server.loadHistory()
+	if err != nil {
+		return fmt.Errorf("failed to load history: %s", err)
+	}
+	SetMetricsReport(server.lastReport())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", server.handleStatus)
+	mux.HandleFunc("/api/scans", server.handleScans)
+	mux.HandleFunc("/api/scans/", server.handleScan)
+	mux.HandleFunc("/metrics", MetricsHandler)
+
+	go server.schedule()
+
+	logrus.WithField("func", "startServe").
+		Infof("Serve on %s, scan %s every %s", serveConfig.Listen, strings.Join(serveConfig.ResourceTypes, ","), interval)
+	httpServer := &http.Server{
+		Addr:              serveConfig.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: serveReadHeaderTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	return httpServer.ListenAndServe()
+}
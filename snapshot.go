@@ -0,0 +1,246 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// Version of the snapshot archive layout, bumped whenever a snapshot written
+// by an older nuageresscan can no longer be read
+const snapshotFormatVersion = 1
+
+// VsdSnapshot is the inventory of one VSD
+type VsdSnapshot struct {
+	AZ                       string                            `json:"az"`
+	L2DomainTemplates        vspk.L2DomainTemplatesList        `json:"l2domaintemplates"`
+	L2Domains                vspk.L2DomainsList                `json:"l2domains"`
+	Domains                  vspk.DomainsList                  `json:"domains"`
+	Subnets                  vspk.SubnetsList                  `json:"subnets"`
+	VPorts                   vspk.VPortsList                   `json:"vports"`
+	VMInterfaces             vspk.VMInterfacesList             `json:"vminterfaces"`
+	PolicyGroups             vspk.PolicyGroupsList             `json:"policygroups"`
+	IngressACLTemplates      vspk.IngressACLTemplatesList      `json:"ingressacltemplates"`
+	EgressACLTemplates       vspk.EgressACLTemplatesList       `json:"egressacltemplates"`
+	IngressACLEntryTemplates vspk.IngressACLEntryTemplatesList `json:"ingressaclentrytemplates"`
+	EgressACLEntryTemplates  vspk.EgressACLEntryTemplatesList  `json:"egressaclentrytemplates"`
+	SharedNetworkResources   vspk.SharedNetworkResourcesList   `json:"sharednetworkresources"`
+	FloatingIps              vspk.FloatingIpsList              `json:"floatingips"`
+}
+
+// Snapshot is everything the scanners read from the Neutron database and the
+// VSDs, the config is kept without its credentials and notifications, so that
+// scanning from a snapshot never notifies
+type Snapshot struct {
+	FormatVersion int             `json:"format_version"`
+	Version       string          `json:"version"`
+	CreatedTime   time.Time       `json:"created_time"`
+	Config        Config          `json:"config"`
+	Neutron       NeutronSnapshot `json:"neutron"`
+	Vsds          []VsdSnapshot   `json:"vsds"`
+}
+
+// snapshot fetches every object class of the inventory into vsdSnapshot
+func (inv *VsdInventory) snapshot(vsdSnapshot *VsdSnapshot) error {
+	var err error
+	fetches := []func() error{
+		func() error { vsdSnapshot.L2DomainTemplates, err = inv.L2DomainTemplates(); return err },
+		func() error { vsdSnapshot.L2Domains, err = inv.L2Domains(); return err },
+		func() error { vsdSnapshot.Domains, err = inv.Domains(); return err },
+		func() error { vsdSnapshot.Subnets, err = inv.Subnets(); return err },
+		func() error { vsdSnapshot.VPorts, err = inv.VPorts(); return err },
+		func() error { vsdSnapshot.VMInterfaces, err = inv.VMInterfaces(); return err },
+		func() error { vsdSnapshot.PolicyGroups, err = inv.PolicyGroups(); return err },
+		func() error { vsdSnapshot.IngressACLTemplates, err = inv.IngressACLTemplates(); return err },
+		func() error { vsdSnapshot.EgressACLTemplates, err = inv.EgressACLTemplates(); return err },
+		func() error { vsdSnapshot.IngressACLEntryTemplates, err = inv.IngressACLEntryTemplates(); return err },
+		func() error { vsdSnapshot.EgressACLEntryTemplates, err = inv.EgressACLEntryTemplates(); return err },
+		func() error { vsdSnapshot.SharedNetworkResources, err = inv.SharedNetworkResources(); return err },
+		func() error { vsdSnapshot.FloatingIps, err = inv.FloatingIps(); return err },
+	}
+	for _, fetchFunc := range fetches {
+		err = fetchFunc()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newVsdInventoryFromSnapshot returns an inventory which has every object class
+// fetched already, so that it never uses its session
+func newVsdInventoryFromSnapshot(vsd *VSD, vsdSnapshot *VsdSnapshot) *VsdInventory {
+	inventory := &VsdInventory{
+		session:                  &VsdSession{VSD: vsd},
+		concurrency:              GetConcurrency(globalConfig),
+		l2DomainTemplates:        vsdSnapshot.L2DomainTemplates,
+		l2Domains:                vsdSnapshot.L2Domains,
+		domains:                  vsdSnapshot.Domains,
+		subnets:                  vsdSnapshot.Subnets,
+		vports:                   vsdSnapshot.VPorts,
+		vmInterfaces:             vsdSnapshot.VMInterfaces,
+		policyGroups:             vsdSnapshot.PolicyGroups,
+		ingressACLTemplates:      vsdSnapshot.IngressACLTemplates,
+		egressACLTemplates:       vsdSnapshot.EgressACLTemplates,
+		ingressACLEntryTemplates: vsdSnapshot.IngressACLEntryTemplates,
+		egressACLEntryTemplates:  vsdSnapshot.EgressACLEntryTemplates,
+		sharedNetworkResources:   vsdSnapshot.SharedNetworkResources,
+		floatingIps:              vsdSnapshot.FloatingIps,
+		fetched:                  make(map[string]bool),
+	}
+	for _, class := range []string{"l2domaintemplates", "l2domains", "domains", "subnets", "vports", "vminterfaces",
+		"policygroups", "ingressacltemplates", "egressacltemplates", "ingressaclentrytemplates", "egressaclentrytemplates",
+		"sharednetworkresources", "floatingips"} {
+		inventory.fetched[class] = true
+	}
+	return inventory
+}
+
+// CaptureSnapshot reads the Neutron database and fetches the inventories of all VSDs
+func CaptureSnapshot(config *Config, createdTime time.Time) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		FormatVersion: snapshotFormatVersion,
+		Version:       Version(),
+		CreatedTime:   createdTime,
+		Config:        *config,
+		Vsds:          make([]VsdSnapshot, len(config.Vsds)),
+	}
+	snapshot.Config.Neu.Username = ""
+	snapshot.Config.Neu.Password = ""
+	snapshot.Config.Notify = NotifyConfig{}
+	snapshot.Config.Vsds = make([]VSD, len(config.Vsds))
+	for i, vsd := range config.Vsds {
+		vsd.Username = ""
+		vsd.Password = ""
+		snapshot.Config.Vsds[i] = vsd
+	}
+
+	logrus.WithField("func", "CaptureSnapshot").
+		Info("SelectNeutronSnapshot")
+	err := SelectNeutronSnapshot(&snapshot.Neutron)
+	if err != nil {
+		return nil, err
+	}
+
+	err = runParallel(len(config.Vsds), len(config.Vsds), func(i int) error {
+		inventory, err := GetVsdInventory(&config.Vsds[i])
+		if err != nil {
+			return err
+		}
+		snapshot.Vsds[i].AZ = config.Vsds[i].AZ
+		return inventory.snapshot(&snapshot.Vsds[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// WriteSnapshot writes the snapshot as gzipped JSON
+func WriteSnapshot(snapshot *Snapshot, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	gzipWriter := gzip.NewWriter(file)
+	err = json.NewEncoder(gzipWriter).Encode(snapshot)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func ReadSnapshot(path string) (*Snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %s", path, err)
+	}
+
+	snapshot := &Snapshot{}
+	err = json.NewDecoder(gzipReader).Decode(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %s", path, err)
+	}
+	if snapshot.FormatVersion != snapshotFormatVersion {
+		return nil, fmt.Errorf("snapshot %s has format version %d, only %d is supported",
+			path, snapshot.FormatVersion, snapshotFormatVersion)
+	}
+	return snapshot, nil
+}
+
+// LoadSnapshot makes the scanners read the snapshot instead of the Neutron database and the VSDs
+func LoadSnapshot(snapshot *Snapshot) {
+	globalConfig = &snapshot.Config
+	neutronSnapshot = &snapshot.Neutron
+
+	vsdInventoriesMutex.Lock()
+	defer vsdInventoriesMutex.Unlock()
+	for i := 0; i < len(snapshot.Vsds); i++ {
+		vsd := GetVSDByAZ(globalConfig, snapshot.Vsds[i].AZ)
+		if vsd == nil {
+			continue
+		}
+		vsdInventories[vsd.AZ] = newVsdInventoryFromSnapshot(vsd, &snapshot.Vsds[i])
+	}
+}
+
+// startSnapshot writes the data of all scanners to a snapshot archive
+func startSnapshot(configPath string, snapshotPath string, options *JobOptions) error {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %s", err)
+	}
+
+	globalConfig = config
+	if options.Concurrency > 0 {
+		globalConfig.Concurrency = options.Concurrency
+	}
+
+	neu := globalConfig.Neu
+	err = OpenDB(neu.Username, neu.Password, neu.IPAddr, neu.Port, neu.DBName)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %s", err)
+	}
+
+	snapshot, err := CaptureSnapshot(globalConfig, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to capture snapshot: %s", err)
+	}
+
+	err = WriteSnapshot(snapshot, snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %s", err)
+	}
+	return nil
+}
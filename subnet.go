@@ -16,10 +16,24 @@
 package main
 
 import (
+	"fmt"
 	"github.com/nuagenetworks/vspk-go/vspk"
 	"github.com/sirupsen/logrus"
 )
 
+// Finding codes
+const (
+	CodeSubnetMappingMissing    string = "subnet-mapping-missing"
+	CodeSubnetMappingDangling   string = "subnet-mapping-dangling"
+	CodeSubnetMappingAzMismatch string = "subnet-mapping-az-mismatch"
+	CodeL2DomainTemplateMissing string = "l2domain-template-missing"
+	CodeL2DomainTemplateOrphan  string = "l2domain-template-orphan"
+	CodeL2DomainMissing         string = "l2domain-missing"
+	CodeL2DomainOrphan          string = "l2domain-orphan"
+	CodeVsdSubnetMissing        string = "vsd-subnet-missing"
+	CodeVsdSubnetOrphan         string = "vsd-subnet-orphan"
+)
+
 // Neutron resources
 var neutronSubnets []Subnet
 var neutronL2domMappings []NuageSubnetL2domMapping
@@ -28,15 +42,24 @@ var neutronL2domMappingSubnetIDMap map[string]*NuageSubnetL2domMapping
 var neutronL2domMappingNuageSubnetIDMap map[string]*NuageSubnetL2domMapping
 var neutronL2domMappingNuageL2domTmpltIDMap map[string]*NuageSubnetL2domMapping
 
-// Nuage resources
-var nuageL2DomainTemplates vspk.L2DomainTemplatesList
-var nuageL2Domains vspk.L2DomainsList
-var nuageSubnets vspk.SubnetsList
-var nuageL2DomainTemplateMap map[string]*vspk.L2DomainTemplate
-var nuageL2DomainMap map[string]*vspk.L2Domain
-var nuageSubnetMap map[string]*vspk.Subnet
+// Nuage resources of the subnets on one VSD
+type nuageSubnetInventory struct {
+	vsd                 *VSD
+	l2DomainTemplates   vspk.L2DomainTemplatesList
+	l2Domains           vspk.L2DomainsList
+	subnets             vspk.SubnetsList
+	l2DomainTemplateMap map[string]*vspk.L2DomainTemplate
+	l2DomainMap         map[string]*vspk.L2Domain
+	subnetMap           map[string]*vspk.Subnet
+}
+
+// Nuage resources, one inventory per AZ in the order of the config
+var nuageSubnetInventories []*nuageSubnetInventory
 
 func dumpAllNeutronSubnetResources() error {
+	neutronSubnets = nil
+	neutronL2domMappings = nil
+
 	logrus.WithField("func", "dumpAllNeutronSubnetResources").
 		Info("SelectAllSubnets")
 	err := SelectAllSubnets(&neutronSubnets)
@@ -71,153 +94,329 @@ func dumpAllNeutronSubnetResources() error {
 }
 
 func dumpAllNuageL2DomainResources() error {
-	for _, vsd := range globalConfig.Vsds {
-		me, err := StartSession(vsd.Username, vsd.Password, vsd.Organization, vsd.URL)
+	nuageSubnetInventories = nil
+
+	// Fetch the VSDs in parallel, the loop below only reads the inventories
+	err := prefetchVsdInventories(func(inventory *VsdInventory) error {
+		_, err := inventory.L2DomainTemplates()
 		if err != nil {
 			return err
 		}
-
-		enterprise, err := FetchEnterpriseByName(me, vsd.NetPartition)
+		_, err = inventory.L2Domains()
 		if err != nil {
 			return err
 		}
+		_, err = inventory.Subnets()
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-		logrus.WithField("func", "dumpAllNuageL2DomainResources").
-			Info("FetchAllL2DomainTemplates from " + vsd.NetPartition)
-		l2domTmplts, err := FetchAllL2DomainTemplates(enterprise)
+	for i := 0; i < len(globalConfig.Vsds); i++ {
+		vsd := &globalConfig.Vsds[i]
+		inventory, err := GetVsdInventory(vsd)
 		if err != nil {
 			return err
 		}
-		nuageL2DomainTemplates = append(nuageL2DomainTemplates, l2domTmplts...)
-		nuageL2DomainTemplateMap = make(map[string]*vspk.L2DomainTemplate)
-		for _, l2domTmplt := range l2domTmplts {
-			nuageL2DomainTemplateMap[l2domTmplt.ID] = l2domTmplt
+
+		subnetInventory := &nuageSubnetInventory{
+			vsd:                 vsd,
+			l2DomainTemplateMap: make(map[string]*vspk.L2DomainTemplate),
+			l2DomainMap:         make(map[string]*vspk.L2Domain),
+			subnetMap:           make(map[string]*vspk.Subnet),
 		}
 
-		logrus.WithField("func", "dumpAllNuageL2DomainResources").
-			Info("FetchAllL2Domains from " + vsd.NetPartition)
-		l2doms, err := FetchAllL2Domains(enterprise)
+		subnetInventory.l2DomainTemplates, err = inventory.L2DomainTemplates()
 		if err != nil {
 			return err
 		}
-		nuageL2Domains = append(nuageL2Domains, l2doms...)
-		nuageL2DomainMap = make(map[string]*vspk.L2Domain)
-		for _, l2dom := range l2doms {
-			nuageL2DomainMap[l2dom.ID] = l2dom
+		for _, l2domTmplt := range subnetInventory.l2DomainTemplates {
+			subnetInventory.l2DomainTemplateMap[l2domTmplt.ID] = l2domTmplt
 		}
 
-		logrus.WithField("func", "dumpAllNuageL2DomainResources").
-			Info("FetchAllDomains from " + vsd.NetPartition)
-		domains, err := FetchAllDomains(enterprise)
+		subnetInventory.l2Domains, err = inventory.L2Domains()
 		if err != nil {
 			return err
 		}
-		for _, domain := range domains {
-			logrus.WithField("func", "dumpAllNuageL2DomainResources").
-				Info("FetchAllSubnets from " + vsd.NetPartition)
-			subnets, err := FetchAllSubnets(domain)
-			if err != nil {
-				return err
-			}
-			nuageSubnets = append(nuageSubnets, subnets...)
+		for _, l2dom := range subnetInventory.l2Domains {
+			subnetInventory.l2DomainMap[l2dom.ID] = l2dom
+		}
+
+		subnetInventory.subnets, err = inventory.Subnets()
+		if err != nil {
+			return err
 		}
-		nuageSubnetMap = make(map[string]*vspk.Subnet)
-		for _, subnet := range nuageSubnets {
-			nuageSubnetMap[subnet.ID] = subnet
+		for _, subnet := range subnetInventory.subnets {
+			subnetInventory.subnetMap[subnet.ID] = subnet
 		}
+
+		nuageSubnetInventories = append(nuageSubnetInventories, subnetInventory)
+	}
+
+	return nil
+}
+
+// mappingVsd returns the VSD on which the nuage objects of the mapping live, known
+// from the net partition of the mapping, or nil when it is not in the config
+func mappingVsd(l2domMapping *NuageSubnetL2domMapping) *VSD {
+	if !l2domMapping.NetPartitionName.Valid {
+		return nil
 	}
+	return lookupVsd(func(config *Config) *VSD {
+		return GetVSDByNetPartition(config, l2domMapping.NetPartitionName.String)
+	})
+}
 
+// nuageSubnetInventoriesOf returns the inventory of the VSD, or all the inventories
+// when the VSD is unknown
+func nuageSubnetInventoriesOf(vsd *VSD) []*nuageSubnetInventory {
+	if vsd == nil {
+		return nuageSubnetInventories
+	}
+	for _, inventory := range nuageSubnetInventories {
+		if inventory.vsd.AZ == vsd.AZ {
+			return []*nuageSubnetInventory{inventory}
+		}
+	}
 	return nil
 }
 
-func scanResForSubnetBaseOnNeutron() {
+// findNuageL2DomainTemplate returns the l2domain template and the VSD it lives on
+func findNuageL2DomainTemplate(inventories []*nuageSubnetInventory, id string) (*vspk.L2DomainTemplate, *VSD) {
+	for _, inventory := range inventories {
+		if l2domTmplt := inventory.l2DomainTemplateMap[id]; l2domTmplt != nil {
+			return l2domTmplt, inventory.vsd
+		}
+	}
+	return nil, nil
+}
+
+// findNuageL2Domain returns the l2domain and the VSD it lives on
+func findNuageL2Domain(inventories []*nuageSubnetInventory, id string) (*vspk.L2Domain, *VSD) {
+	for _, inventory := range inventories {
+		if l2dom := inventory.l2DomainMap[id]; l2dom != nil {
+			return l2dom, inventory.vsd
+		}
+	}
+	return nil, nil
+}
+
+// findNuageSubnet returns the subnet and the VSD it lives on
+func findNuageSubnet(inventories []*nuageSubnetInventory, id string) (*vspk.Subnet, *VSD) {
+	for _, inventory := range inventories {
+		if subnet := inventory.subnetMap[id]; subnet != nil {
+			return subnet, inventory.vsd
+		}
+	}
+	return nil, nil
+}
+
+func scanResForSubnetBaseOnNeutron() []Finding {
+	var findings []Finding
 	for _, neutronSubnet := range neutronSubnets {
 		neutronL2domMapping := neutronL2domMappingSubnetIDMap[neutronSubnet.ID]
 		if neutronL2domMapping == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNeutron", "object": "neutron"}).
-				Warningf("nuage_subnet_l2dom_mapping.subnet_id %s was not found", neutronSubnet.ID)
+			findings = append(findings, Finding{
+				ResourceType: ResTypeSubnet,
+				Side:         SideNeutron,
+				NeutronID:    neutronSubnet.ID,
+				Code:         CodeSubnetMappingMissing,
+				Message:      fmt.Sprintf("nuage_subnet_l2dom_mapping.subnet_id %s was not found", neutronSubnet.ID),
+			})
 			continue
 		}
 
+		// The objects are only looked for on the VSD of the mapping when it is known
+		vsd := mappingVsd(neutronL2domMapping)
+		if scanFilter.skipsVsd(vsd) {
+			continue
+		}
+		inventories := nuageSubnetInventoriesOf(vsd)
 		if neutronL2domMapping.NuageL2domTmpltID.Valid {
-			nuageL2DomainTemplate := nuageL2DomainTemplateMap[neutronL2domMapping.NuageL2domTmpltID.String]
-			if nuageL2DomainTemplate == nil {
-				logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNeutron", "object": "nuage"}).
-					Warningf("l2domain template %s was not found", neutronL2domMapping.NuageL2domTmpltID.String)
+			nuageL2DomainTemplate, tmpltVsd := findNuageL2DomainTemplate(inventories, neutronL2domMapping.NuageL2domTmpltID.String)
+			nuageL2Domain, l2domVsd := findNuageL2Domain(inventories, neutronL2domMapping.NuageSubnetID)
+			// Otherwise the l2domain and its template live on the same VSD
+			if vsd == nil && tmpltVsd != nil {
+				vsd = tmpltVsd
+			} else if vsd == nil {
+				vsd = l2domVsd
 			}
 
-			nuageL2Domain := nuageL2DomainMap[neutronL2domMapping.NuageSubnetID]
+			if nuageL2DomainTemplate == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNuage,
+					NeutronID:    neutronSubnet.ID,
+					VsdID:        neutronL2domMapping.NuageL2domTmpltID.String,
+					Code:         CodeL2DomainTemplateMissing,
+					Message:      fmt.Sprintf("l2domain template %s was not found", neutronL2domMapping.NuageL2domTmpltID.String),
+				}.withVsd(vsd))
+			}
 			if nuageL2Domain == nil {
-				logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNeutron", "object": "nuage"}).
-					Warningf("l2domain %s was not found", neutronL2domMapping.NuageSubnetID)
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNuage,
+					NeutronID:    neutronSubnet.ID,
+					VsdID:        neutronL2domMapping.NuageSubnetID,
+					Code:         CodeL2DomainMissing,
+					Message:      fmt.Sprintf("l2domain %s was not found", neutronL2domMapping.NuageSubnetID),
+				}.withVsd(vsd))
 			}
 		} else {
-			nuageSubnet := nuageSubnetMap[neutronL2domMapping.NuageSubnetID]
+			nuageSubnet, _ := findNuageSubnet(inventories, neutronL2domMapping.NuageSubnetID)
 			if nuageSubnet == nil {
-				logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNeutron", "object": "nuage"}).
-					Warningf("subnet %s was not found", neutronL2domMapping.NuageSubnetID)
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNuage,
+					NeutronID:    neutronSubnet.ID,
+					VsdID:        neutronL2domMapping.NuageSubnetID,
+					Code:         CodeVsdSubnetMissing,
+					Message:      fmt.Sprintf("subnet %s was not found", neutronL2domMapping.NuageSubnetID),
+				}.withVsd(vsd))
 			}
 		}
 	}
+
+	return findings
 }
 
-func scanResForSubnetBaseOnNuage() {
-	for _, nuageL2DomainTemplate := range nuageL2DomainTemplates {
-		neutronL2domMapping := neutronL2domMappingNuageL2domTmpltIDMap[nuageL2DomainTemplate.ID]
-		if neutronL2domMapping == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNuage", "object": "neutron"}).
-				Warningf("nuage_subnet_l2dom_mapping.nuage_l2dom_tmplt_id %s was not found", nuageL2DomainTemplate.ID)
-			continue
-		}
-		neutronSubnet := neutronSubnetMap[neutronL2domMapping.SubnetID]
-		if neutronSubnet == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNuage", "object": "neutron"}).
-				Warningf("subnet.id %s was not found", neutronL2domMapping.SubnetID)
+func scanResForSubnetBaseOnNuage() []Finding {
+	var findings []Finding
+	for _, inventory := range nuageSubnetInventories {
+		for _, nuageL2DomainTemplate := range inventory.l2DomainTemplates {
+			neutronL2domMapping := neutronL2domMappingNuageL2domTmpltIDMap[nuageL2DomainTemplate.ID]
+			if neutronL2domMapping == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					VsdID:        nuageL2DomainTemplate.ID,
+					ExternalID:   nuageL2DomainTemplate.ExternalID,
+					Code:         CodeL2DomainTemplateOrphan,
+					Message:      fmt.Sprintf("nuage_subnet_l2dom_mapping.nuage_l2dom_tmplt_id %s was not found", nuageL2DomainTemplate.ID),
+				}.withVsd(inventory.vsd))
+				continue
+			}
+			if vsd := mappingVsd(neutronL2domMapping); vsd != nil && vsd.AZ != inventory.vsd.AZ {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					NeutronID:    neutronL2domMapping.SubnetID,
+					VsdID:        nuageL2DomainTemplate.ID,
+					ExternalID:   nuageL2DomainTemplate.ExternalID,
+					Code:         CodeSubnetMappingAzMismatch,
+					Message:      fmt.Sprintf("l2domain template %s is mapped to subnet.id %s of az %s", nuageL2DomainTemplate.ID, neutronL2domMapping.SubnetID, vsd.AZ),
+				}.withVsd(inventory.vsd))
+				continue
+			}
+			neutronSubnet := neutronSubnetMap[neutronL2domMapping.SubnetID]
+			if neutronSubnet == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					NeutronID:    neutronL2domMapping.SubnetID,
+					VsdID:        nuageL2DomainTemplate.ID,
+					ExternalID:   nuageL2DomainTemplate.ExternalID,
+					Code:         CodeSubnetMappingDangling,
+					Message:      fmt.Sprintf("subnet.id %s was not found", neutronL2domMapping.SubnetID),
+				}.withVsd(inventory.vsd))
+			}
 		}
-	}
 
-	for _, nuageL2Domain := range nuageL2Domains {
-		neutronL2domMapping := neutronL2domMappingNuageSubnetIDMap[nuageL2Domain.ID]
-		if neutronL2domMapping == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNuage", "object": "neutron"}).
-				Warningf("nuage_subnet_l2dom_mapping.nuage_subnet_id %s was not found", nuageL2Domain.ID)
-			continue
-		}
-		neutronSubnet := neutronSubnetMap[neutronL2domMapping.SubnetID]
-		if neutronSubnet == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNuage", "object": "neutron"}).
-				Warningf("subnet.id %s was not found", neutronL2domMapping.SubnetID)
+		for _, nuageL2Domain := range inventory.l2Domains {
+			neutronL2domMapping := neutronL2domMappingNuageSubnetIDMap[nuageL2Domain.ID]
+			if neutronL2domMapping == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					VsdID:        nuageL2Domain.ID,
+					ExternalID:   nuageL2Domain.ExternalID,
+					Code:         CodeL2DomainOrphan,
+					Message:      fmt.Sprintf("nuage_subnet_l2dom_mapping.nuage_subnet_id %s was not found", nuageL2Domain.ID),
+				}.withVsd(inventory.vsd))
+				continue
+			}
+			if vsd := mappingVsd(neutronL2domMapping); vsd != nil && vsd.AZ != inventory.vsd.AZ {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					NeutronID:    neutronL2domMapping.SubnetID,
+					VsdID:        nuageL2Domain.ID,
+					ExternalID:   nuageL2Domain.ExternalID,
+					Code:         CodeSubnetMappingAzMismatch,
+					Message:      fmt.Sprintf("l2domain %s is mapped to subnet.id %s of az %s", nuageL2Domain.ID, neutronL2domMapping.SubnetID, vsd.AZ),
+				}.withVsd(inventory.vsd))
+				continue
+			}
+			neutronSubnet := neutronSubnetMap[neutronL2domMapping.SubnetID]
+			if neutronSubnet == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					NeutronID:    neutronL2domMapping.SubnetID,
+					VsdID:        nuageL2Domain.ID,
+					ExternalID:   nuageL2Domain.ExternalID,
+					Code:         CodeSubnetMappingDangling,
+					Message:      fmt.Sprintf("subnet.id %s was not found", neutronL2domMapping.SubnetID),
+				}.withVsd(inventory.vsd))
+			}
 		}
-	}
 
-	for _, nuageSubnet := range nuageSubnets {
-		neutronL2domMapping := neutronL2domMappingNuageSubnetIDMap[nuageSubnet.ID]
-		if neutronL2domMapping == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNuage", "object": "neutron"}).
-				Warningf("nuage_subnet_l2dom_mapping.nuage_subnet_id %s was not found", nuageSubnet.ID)
-			continue
-		}
-		neutronSubnet := neutronSubnetMap[neutronL2domMapping.SubnetID]
-		if neutronSubnet == nil {
-			logrus.WithFields(logrus.Fields{"func": "scanResForSubnetBaseOnNuage", "object": "neutron"}).
-				Warningf("subnet.id %s was not found", neutronL2domMapping.SubnetID)
+		for _, nuageSubnet := range inventory.subnets {
+			neutronL2domMapping := neutronL2domMappingNuageSubnetIDMap[nuageSubnet.ID]
+			if neutronL2domMapping == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					VsdID:        nuageSubnet.ID,
+					ExternalID:   nuageSubnet.ExternalID,
+					Code:         CodeVsdSubnetOrphan,
+					Message:      fmt.Sprintf("nuage_subnet_l2dom_mapping.nuage_subnet_id %s was not found", nuageSubnet.ID),
+				}.withVsd(inventory.vsd))
+				continue
+			}
+			if vsd := mappingVsd(neutronL2domMapping); vsd != nil && vsd.AZ != inventory.vsd.AZ {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					NeutronID:    neutronL2domMapping.SubnetID,
+					VsdID:        nuageSubnet.ID,
+					ExternalID:   nuageSubnet.ExternalID,
+					Code:         CodeSubnetMappingAzMismatch,
+					Message:      fmt.Sprintf("subnet %s is mapped to subnet.id %s of az %s", nuageSubnet.ID, neutronL2domMapping.SubnetID, vsd.AZ),
+				}.withVsd(inventory.vsd))
+				continue
+			}
+			neutronSubnet := neutronSubnetMap[neutronL2domMapping.SubnetID]
+			if neutronSubnet == nil {
+				findings = append(findings, Finding{
+					ResourceType: ResTypeSubnet,
+					Side:         SideNeutron,
+					NeutronID:    neutronL2domMapping.SubnetID,
+					VsdID:        nuageSubnet.ID,
+					ExternalID:   nuageSubnet.ExternalID,
+					Code:         CodeSubnetMappingDangling,
+					Message:      fmt.Sprintf("subnet.id %s was not found", neutronL2domMapping.SubnetID),
+				}.withVsd(inventory.vsd))
+			}
 		}
 	}
+
+	return findings
 }
 
-func scanResForSubnet() {
+func scanResForSubnet() ([]Finding, error) {
 	err := dumpAllNeutronSubnetResources()
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"func": "dumpAllNeutronSubnetResources", "object": "neutron"}).Error(err)
-		return
+		return nil, fmt.Errorf("dumpAllNeutronSubnetResources: %s", err)
 	}
 
 	err = dumpAllNuageL2DomainResources()
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"func": "dumpAllNuageL2DomainResources", "object": "nuage"}).Error(err)
-		return
+		return nil, fmt.Errorf("dumpAllNuageL2DomainResources: %s", err)
 	}
 
-	scanResForSubnetBaseOnNeutron()
-	scanResForSubnetBaseOnNuage()
+	findings := scanResForSubnetBaseOnNeutron()
+	findings = append(findings, scanResForSubnetBaseOnNuage()...)
+	return findings, nil
 }
@@ -0,0 +1,112 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Two fake VSDs, one per AZ
+var testVsds = []VSD{
+	{URL: "https://vsd-bj:8443", NetPartition: "OpenStack_bj", CMSID: "cms-bj", AZ: "bj"},
+	{URL: "https://vsd-cs:8443", NetPartition: "OpenStack_cs", CMSID: "cms-cs", AZ: "cs"},
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// loadTestSnapshot makes the scanners read the snapshot instead of the database
+// and the VSDs for the duration of the test
+func loadTestSnapshot(t testing.TB, snapshot *Snapshot) {
+	resetVsdSessions()
+	resetVsdInventories()
+	scanFilter = nil
+	LoadSnapshot(snapshot)
+	t.Cleanup(func() {
+		resetVsdInventories()
+		neutronSnapshot = nil
+		globalConfig = nil
+		scanFilter = nil
+	})
+}
+
+// findingKeys returns the code, the IDs and the AZ of the findings, sorted
+func findingKeys(findings []Finding) []string {
+	keys := []string{}
+	for _, finding := range findings {
+		keys = append(keys, fmt.Sprintf("%s %s %s %s", finding.Code, finding.NeutronID, finding.VsdID, finding.AZ))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestScanResForSubnetPerAZ(t *testing.T) {
+	loadTestSnapshot(t, &Snapshot{
+		Config: Config{Vsds: testVsds},
+		Neutron: NeutronSnapshot{
+			Subnets: []Subnet{{ID: "s1"}, {ID: "s2"}, {ID: "s3"}, {ID: "s4"}, {ID: "s5"}},
+			NuageSubnetL2domMappings: []NuageSubnetL2domMapping{
+				{SubnetID: "s1", NuageSubnetID: "l1", NuageL2domTmpltID: nullString("t1"), NetPartitionName: nullString("OpenStack_bj")},
+				{SubnetID: "s2", NuageSubnetID: "l2", NuageL2domTmpltID: nullString("t2"), NetPartitionName: nullString("OpenStack_cs")},
+				{SubnetID: "s3", NuageSubnetID: "n3", NetPartitionName: nullString("OpenStack_cs")},
+				{SubnetID: "s4", NuageSubnetID: "n4"},
+				{SubnetID: "s5", NuageSubnetID: "n5"},
+			},
+		},
+		Vsds: []VsdSnapshot{
+			{
+				AZ:                "bj",
+				L2DomainTemplates: vspk.L2DomainTemplatesList{{ID: "t1", ExternalID: "s1@cms-bj"}, {ID: "t2", ExternalID: "s2@cms-bj"}},
+				L2Domains:         vspk.L2DomainsList{{ID: "l1", ExternalID: "s1@cms-bj"}, {ID: "l2", ExternalID: "s2@cms-bj"}},
+				Subnets:           vspk.SubnetsList{{ID: "n3", ExternalID: "s3@cms-bj"}},
+			},
+			{
+				AZ:        "cs",
+				L2Domains: vspk.L2DomainsList{{ID: "l9", ExternalID: "s9@cms-cs"}},
+				Subnets:   vspk.SubnetsList{{ID: "n3", ExternalID: "s3@cms-cs"}, {ID: "n4", ExternalID: "s4@cms-cs"}},
+			},
+		},
+	})
+
+	findings, err := scanResForSubnet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"l2domain-missing s2 l2 cs",
+		"l2domain-orphan  l9 cs",
+		"l2domain-template-missing s2 t2 cs",
+		"subnet-mapping-az-mismatch s2 l2 bj",
+		"subnet-mapping-az-mismatch s2 t2 bj",
+		"subnet-mapping-az-mismatch s3 n3 bj",
+		"vsd-subnet-missing s5 n5 ",
+	}
+	if keys := findingKeys(findings); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("findings %q, expected %q", keys, expected)
+	}
+	for _, finding := range findings {
+		if finding.AZ != "" && finding.VsdURL != GetVSDByAZ(globalConfig, finding.AZ).URL {
+			t.Errorf("finding %s of az %s has VSD URL %s", finding.Code, finding.AZ, finding.VsdURL)
+		}
+	}
+}
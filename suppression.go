@@ -0,0 +1,162 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+)
+
+/*
+Sample suppression file, every field set in an entry has to match, expires
+is the last day the entry applies:
+{
+  "suppressions": [
+    {
+      "code": "domain-external-id-empty",
+      "expires": "2022-06-30",
+      "reason": "domains created by hand for the lab"
+    },
+    {
+      "resource_type": "subnet",
+      "id": "3f2a*",
+      "expires": "2022-03-31",
+      "reason": "subnets of the legacy networks"
+    },
+    {
+      "fingerprint": "74127a2cab1e55a6",
+      "expires": "2022-01-31",
+      "reason": "known issue, fixed in the next release"
+    }
+  ]
+}
+*/
+
+const suppressionDateLayout = "2006-01-02"
+
+// Suppression accepts the findings it matches, ID is a glob pattern matched
+// against the neutron ID, the VSD ID and the externalID
+type Suppression struct {
+	Fingerprint  string `json:"fingerprint,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+	ID           string `json:"id,omitempty"`
+	Code         string `json:"code,omitempty"`
+	Expires      string `json:"expires"`
+	Reason       string `json:"reason"`
+
+	expiresTime time.Time
+}
+
+type SuppressionFile struct {
+	Suppressions []Suppression `json:"suppressions"`
+}
+
+func LoadSuppressions(suppressionPath string) ([]Suppression, error) {
+	buf, err := ioutil.ReadFile(suppressionPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var suppressionFile SuppressionFile
+	err = json.Unmarshal(buf, &suppressionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	suppressions := suppressionFile.Suppressions
+	for i := 0; i < len(suppressions); i++ {
+		suppression := &suppressions[i]
+		if suppression.Fingerprint == "" && suppression.ResourceType == "" && suppression.ID == "" && suppression.Code == "" {
+			return nil, fmt.Errorf("suppression %d matches every finding", i+1)
+		}
+		if suppression.Reason == "" {
+			return nil, fmt.Errorf("suppression %d has no reason", i+1)
+		}
+		if _, err := path.Match(suppression.ID, ""); err != nil {
+			return nil, fmt.Errorf("suppression %d has an invalid id pattern %s", i+1, suppression.ID)
+		}
+		expiresTime, err := time.ParseInLocation(suppressionDateLayout, suppression.Expires, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("suppression %d has an invalid expires %s, expected YYYY-MM-DD", i+1, suppression.Expires)
+		}
+		suppression.expiresTime = expiresTime.AddDate(0, 0, 1)
+	}
+
+	return suppressions, nil
+}
+
+func (s *Suppression) isExpired(now time.Time) bool {
+	return !now.Before(s.expiresTime)
+}
+
+func matchID(pattern string, id string) bool {
+	if id == "" {
+		return false
+	}
+	matched, _ := path.Match(pattern, id)
+	return matched
+}
+
+func (s *Suppression) match(finding *Finding) bool {
+	if s.Fingerprint != "" && s.Fingerprint != finding.fingerprint() {
+		return false
+	}
+	if s.ResourceType != "" && s.ResourceType != finding.ResourceType {
+		return false
+	}
+	if s.Code != "" && s.Code != finding.Code {
+		return false
+	}
+	if s.ID != "" && !matchID(s.ID, finding.NeutronID) && !matchID(s.ID, finding.VsdID) && !matchID(s.ID, finding.ExternalID) {
+		return false
+	}
+	return true
+}
+
+// filterSuppressedFindings returns the findings no unexpired suppression matches,
+// and the number of the others
+func filterSuppressedFindings(suppressions []Suppression, findings []Finding, now time.Time) ([]Finding, int) {
+	var unsuppressedFindings []Finding
+	suppressed := 0
+	for i := 0; i < len(findings); i++ {
+		isSuppressed := false
+		for j := 0; j < len(suppressions); j++ {
+			if !suppressions[j].isExpired(now) && suppressions[j].match(&findings[i]) {
+				isSuppressed = true
+				break
+			}
+		}
+		if isSuppressed {
+			suppressed++
+			continue
+		}
+		unsuppressedFindings = append(unsuppressedFindings, findings[i])
+	}
+	return unsuppressedFindings, suppressed
+}
+
+func expiredSuppressions(suppressions []Suppression, now time.Time) []Suppression {
+	var expired []Suppression
+	for _, suppression := range suppressions {
+		if suppression.isExpired(now) {
+			expired = append(expired, suppression)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeTestSuppressions writes the suppression file and returns its path
+func writeTestSuppressions(t *testing.T, dir string, data string) string {
+	suppressionPath := filepath.Join(dir, "suppressions.json")
+	err := ioutil.WriteFile(suppressionPath, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return suppressionPath
+}
+
+func TestSuppressionMatch(t *testing.T) {
+	finding := Finding{ResourceType: ResTypePort, Code: CodeVPortOrphan, NeutronID: "3f2a-port", VsdID: "vport-1", ExternalID: "3f2a-port@cms-bj"}
+	for _, test := range []struct {
+		name        string
+		suppression Suppression
+		match       bool
+	}{
+		{"fingerprint", Suppression{Fingerprint: finding.fingerprint()}, true},
+		{"other fingerprint", Suppression{Fingerprint: "74127a2cab1e55a6"}, false},
+		{"neutron ID glob", Suppression{ID: "3f2a*"}, true},
+		{"VSD ID glob", Suppression{ID: "vport-?"}, true},
+		{"externalID glob", Suppression{ID: "*@cms-bj"}, true},
+		{"other ID", Suppression{ID: "4e*"}, false},
+		{"code", Suppression{Code: CodeVPortOrphan}, true},
+		{"other code", Suppression{Code: CodeVPortMissing}, false},
+		{"code and other resource type", Suppression{ResourceType: ResTypeSubnet, Code: CodeVPortOrphan}, false},
+		{"resource type and ID", Suppression{ResourceType: ResTypePort, ID: "3f2a*"}, true},
+	} {
+		if match := test.suppression.match(&finding); match != test.match {
+			t.Errorf("%s: match is %t", test.name, match)
+		}
+	}
+}
+
+func TestLoadSuppressionsFailures(t *testing.T) {
+	dir := t.TempDir()
+	for _, data := range []string{
+		`{"suppressions": [{"expires": "2022-01-31", "reason": "everything"}]}`,
+		`{"suppressions": [{"code": "vport-orphan", "expires": "2022-01-31"}]}`,
+		`{"suppressions": [{"id": "[", "expires": "2022-01-31", "reason": "bad pattern"}]}`,
+		`{"suppressions": [{"code": "vport-orphan", "expires": "31/01/2022", "reason": "bad date"}]}`,
+	} {
+		_, err := LoadSuppressions(writeTestSuppressions(t, dir, data))
+		if err == nil {
+			t.Errorf("suppressions %s were loaded", data)
+		}
+	}
+}
+
+func TestSuppressionExpiry(t *testing.T) {
+	suppressions, err := LoadSuppressions(writeTestSuppressions(t, t.TempDir(),
+		`{"suppressions": [{"code": "vport-orphan", "expires": "2022-01-31", "reason": "known issue"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The suppression applies until the end of its expires day
+	findings := []Finding{{ResourceType: ResTypePort, Code: CodeVPortOrphan, VsdID: "vport-1"}}
+	lastDay := time.Date(2022, 1, 31, 23, 59, 0, 0, time.Local)
+	if kept, suppressed := filterSuppressedFindings(suppressions, findings, lastDay); len(kept) != 0 || suppressed != 1 {
+		t.Errorf("on the last day %d findings were kept and %d suppressed", len(kept), suppressed)
+	}
+	if expired := expiredSuppressions(suppressions, lastDay); len(expired) != 0 {
+		t.Errorf("suppressions %+v expired on the last day", expired)
+	}
+
+	nextDay := lastDay.Add(time.Hour)
+	if kept, suppressed := filterSuppressedFindings(suppressions, findings, nextDay); len(kept) != 1 || suppressed != 0 {
+		t.Errorf("after expiry %d findings were kept and %d suppressed", len(kept), suppressed)
+	}
+	if expired := expiredSuppressions(suppressions, nextDay); len(expired) != 1 {
+		t.Errorf("suppressions %+v expired after the expires day", expired)
+	}
+}
+
+func TestStartJobCountsSuppressedFindings(t *testing.T) {
+	loadTestSnapshot(t, &Snapshot{Config: Config{Vsds: testVsds}})
+	dir := t.TempDir()
+
+	// s1, s2 and s3 have no VSD subnet, l9 has no Neutron subnet
+	snapshotPath := filepath.Join(dir, "snapshot.json.gz")
+	err := WriteSnapshot(&Snapshot{
+		FormatVersion: snapshotFormatVersion,
+		Config:        Config{Vsds: testVsds},
+		Neutron: NeutronSnapshot{
+			Subnets: []Subnet{{ID: "s1"}, {ID: "s2"}, {ID: "s3"}},
+			NuageSubnetL2domMappings: []NuageSubnetL2domMapping{
+				{SubnetID: "s1", NuageSubnetID: "n1", NetPartitionName: nullString("OpenStack_bj")},
+				{SubnetID: "s2", NuageSubnetID: "n2", NetPartitionName: nullString("OpenStack_bj")},
+				{SubnetID: "s3", NuageSubnetID: "n3", NetPartitionName: nullString("OpenStack_bj")},
+			},
+		},
+		Vsds: []VsdSnapshot{{AZ: "bj", L2Domains: vspk.L2DomainsList{{ID: "l9", ExternalID: "s9@cms-bj"}}}, {AZ: "cs"}},
+	}, snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suppressionPath := writeTestSuppressions(t, dir, `{"suppressions": [
+		{"resource_type": "subnet", "id": "s1", "expires": "2999-12-31", "reason": "lab subnet"},
+		{"code": "l2domain-orphan", "expires": "2999-12-31", "reason": "lab domains"},
+		{"id": "s2", "expires": "2000-01-31", "reason": "expired"}
+	]}`)
+
+	report, err := startJob("", ResTypeSubnet, &JobOptions{FromSnapshot: snapshotPath, Suppressions: suppressionPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"vsd-subnet-missing s2 n2 bj", "vsd-subnet-missing s3 n3 bj"}
+	if keys := findingKeys(report.Findings[ResTypeSubnet]); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("findings %q, expected %q", keys, expected)
+	}
+	if !reflect.DeepEqual(report.Suppressed, map[string]int{ResTypeSubnet: 2}) {
+		t.Errorf("suppressed %v", report.Suppressed)
+	}
+	if len(report.ExpiredSuppressions) != 1 || report.ExpiredSuppressions[0].ID != "s2" {
+		t.Errorf("expired suppressions %+v", report.ExpiredSuppressions)
+	}
+}
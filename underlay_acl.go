@@ -15,5 +15,277 @@
 
 package main
 
-func scanResForUnderlayAcl() {
+import (
+	"fmt"
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	nuageEnabled   string = "ENABLED"
+	nuageDisabled  string = "DISABLED"
+	nuageInherited string = "INHERITED"
+
+	underlayNetworkType string = "UNDERLAY_INTERNET_POLICYGROUP"
+)
+
+// Finding codes
+const (
+	CodeSubnetUnderlayMismatch     string = "subnet-underlay-mismatch"
+	CodeSubnetPATMismatch          string = "subnet-pat-mismatch"
+	CodeDomainUnderlayMismatch     string = "domain-underlay-mismatch"
+	CodeDomainPATMismatch          string = "domain-pat-mismatch"
+	CodeUnderlayACLEntryUnexpected string = "underlay-acl-entry-unexpected"
+)
+
+// Expected VSD subnet underlayEnabled and PATEnabled for each nuage_underlay value
+var nuageUnderlayValues = map[string][2]string{
+	"off":   {nuageDisabled, nuageDisabled},
+	"route": {nuageEnabled, nuageDisabled},
+	"snat":  {nuageEnabled, nuageEnabled},
+}
+
+// Neutron resources
+var neutronRouterGateways []RouterGateway
+var neutronSubnetUnderlayParameters []NuageSubnetParameter
+var neutronRouterGatewayMap map[string]*RouterGateway
+
+// Nuage resources
+var nuageUnderlayDomains vspk.DomainsList
+var nuageUnderlaySubnets vspk.SubnetsList
+var nuageUnderlaySubnetMap map[string]*vspk.Subnet
+var nuageUnderlayACLEntryIDsMap map[string][]string
+
+func dumpAllNeutronUnderlayResources() error {
+	neutronRouterGateways = nil
+	neutronSubnetUnderlayParameters = nil
+
+	logrus.WithField("func", "dumpAllNeutronUnderlayResources").
+		Info("SelectAllRouterGateways")
+	err := SelectAllRouterGateways(&neutronRouterGateways)
+	if err != nil {
+		return err
+	}
+	neutronRouterGatewayMap = make(map[string]*RouterGateway)
+	for i := 0; i < len(neutronRouterGateways); i++ {
+		routerGateway := &neutronRouterGateways[i]
+		neutronRouterGatewayMap[routerGateway.ID] = routerGateway
+	}
+
+	logrus.WithField("func", "dumpAllNeutronUnderlayResources").
+		Info("SelectNuageSubnetParametersByName nuage_underlay")
+	err = SelectNuageSubnetParametersByName(&neutronSubnetUnderlayParameters, "nuage_underlay")
+	if err != nil {
+		return err
+	}
+
+	// The l2dom mappings give the VSD subnet of each neutron subnet
+	return dumpAllNeutronSubnetResources()
+}
+
+func dumpAllNuageUnderlayResources() error {
+	nuageUnderlayDomains = nil
+	nuageUnderlaySubnets = nil
+	nuageUnderlaySubnetMap = make(map[string]*vspk.Subnet)
+	nuageUnderlayACLEntryIDsMap = make(map[string][]string)
+
+	// Fetch the VSDs in parallel, the loop below only reads the inventories
+	err := prefetchVsdInventories(func(inventory *VsdInventory) error {
+		_, err := inventory.Domains()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.Subnets()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.IngressACLEntryTemplates()
+		if err != nil {
+			return err
+		}
+		_, err = inventory.EgressACLEntryTemplates()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(globalConfig.Vsds); i++ {
+		inventory, err := GetVsdInventory(&globalConfig.Vsds[i])
+		if err != nil {
+			return err
+		}
+
+		domains, err := inventory.Domains()
+		if err != nil {
+			return err
+		}
+		nuageUnderlayDomains = append(nuageUnderlayDomains, domains...)
+
+		subnets, err := inventory.Subnets()
+		if err != nil {
+			return err
+		}
+		nuageUnderlaySubnets = append(nuageUnderlaySubnets, subnets...)
+
+		// Underlay acl entries are indexed by the domain of their acl template
+		aclTemplateDomainIDMap := make(map[string]string)
+		ingressACLTemplates, err := inventory.IngressACLTemplates()
+		if err != nil {
+			return err
+		}
+		for _, ingressACLTemplate := range ingressACLTemplates {
+			if ingressACLTemplate.ParentType == vspk.DomainIdentity.Name {
+				aclTemplateDomainIDMap[ingressACLTemplate.ID] = ingressACLTemplate.ParentID
+			}
+		}
+		egressACLTemplates, err := inventory.EgressACLTemplates()
+		if err != nil {
+			return err
+		}
+		for _, egressACLTemplate := range egressACLTemplates {
+			if egressACLTemplate.ParentType == vspk.DomainIdentity.Name {
+				aclTemplateDomainIDMap[egressACLTemplate.ID] = egressACLTemplate.ParentID
+			}
+		}
+
+		ingressACLEntryTemplates, err := inventory.IngressACLEntryTemplates()
+		if err != nil {
+			return err
+		}
+		for _, entry := range ingressACLEntryTemplates {
+			domainID := aclTemplateDomainIDMap[entry.ParentID]
+			if domainID != "" && entry.NetworkType == underlayNetworkType {
+				nuageUnderlayACLEntryIDsMap[domainID] = append(nuageUnderlayACLEntryIDsMap[domainID], entry.ID)
+			}
+		}
+		egressACLEntryTemplates, err := inventory.EgressACLEntryTemplates()
+		if err != nil {
+			return err
+		}
+		for _, entry := range egressACLEntryTemplates {
+			domainID := aclTemplateDomainIDMap[entry.ParentID]
+			if domainID != "" && entry.NetworkType == underlayNetworkType {
+				nuageUnderlayACLEntryIDsMap[domainID] = append(nuageUnderlayACLEntryIDsMap[domainID], entry.ID)
+			}
+		}
+	}
+
+	for _, subnet := range nuageUnderlaySubnets {
+		nuageUnderlaySubnetMap[subnet.ID] = subnet
+	}
+
+	return nil
+}
+
+func scanResForUnderlayAclBaseOnNeutron() []Finding {
+	var findings []Finding
+	for _, neutronSubnetUnderlayParameter := range neutronSubnetUnderlayParameters {
+		expected, ok := nuageUnderlayValues[neutronSubnetUnderlayParameter.ParameterValue]
+		if !ok {
+			expected = [2]string{nuageInherited, nuageInherited}
+		}
+
+		neutronL2domMapping := neutronL2domMappingSubnetIDMap[neutronSubnetUnderlayParameter.SubnetID]
+		if neutronL2domMapping == nil || neutronL2domMapping.NuageL2domTmpltID.Valid {
+			continue
+		}
+		nuageSubnet := nuageUnderlaySubnetMap[neutronL2domMapping.NuageSubnetID]
+		if nuageSubnet == nil {
+			continue
+		}
+
+		finding := Finding{
+			ResourceType: ResTypeUnderlayacl,
+			Side:         SideNuage,
+			NeutronID:    neutronSubnetUnderlayParameter.SubnetID,
+			VsdID:        nuageSubnet.ID,
+			ExternalID:   nuageSubnet.ExternalID,
+		}.withVsd(GetVSDByExternalID(globalConfig, nuageSubnet.ExternalID))
+		if nuageSubnet.UnderlayEnabled != expected[0] {
+			finding.Code = CodeSubnetUnderlayMismatch
+			finding.Message = fmt.Sprintf("subnet %s has underlayEnabled %s but nuage_underlay of subnet %s is %s",
+				nuageSubnet.ID, nuageSubnet.UnderlayEnabled, neutronSubnetUnderlayParameter.SubnetID, neutronSubnetUnderlayParameter.ParameterValue)
+			findings = append(findings, finding)
+		}
+		if nuageSubnet.PATEnabled != expected[1] {
+			finding.Code = CodeSubnetPATMismatch
+			finding.Message = fmt.Sprintf("subnet %s has PATEnabled %s but nuage_underlay of subnet %s is %s",
+				nuageSubnet.ID, nuageSubnet.PATEnabled, neutronSubnetUnderlayParameter.SubnetID, neutronSubnetUnderlayParameter.ParameterValue)
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings
+}
+
+func scanResForUnderlayAclBaseOnNuage() []Finding {
+	var findings []Finding
+	for _, nuageDomain := range nuageUnderlayDomains {
+		if nuageDomain.ExternalID == "" {
+			continue
+		}
+		neutronRouterID := strings.Split(nuageDomain.ExternalID, "@")[0]
+		finding := Finding{
+			ResourceType: ResTypeUnderlayacl,
+			Side:         SideNuage,
+			NeutronID:    neutronRouterID,
+			VsdID:        nuageDomain.ID,
+			ExternalID:   nuageDomain.ExternalID,
+		}.withVsd(GetVSDByExternalID(globalConfig, nuageDomain.ExternalID))
+
+		neutronRouterGateway := neutronRouterGatewayMap[neutronRouterID]
+		// Routers without external gateway keep the plugin defaults
+		if neutronRouterGateway == nil || !neutronRouterGateway.GwPortID.Valid {
+			if len(nuageUnderlayACLEntryIDsMap[nuageDomain.ID]) > 0 && nuageDomain.UnderlayEnabled != nuageEnabled {
+				finding.Code = CodeUnderlayACLEntryUnexpected
+				finding.Message = fmt.Sprintf("domain %s has underlay acl entries %s but underlay is not enabled",
+					nuageDomain.ID, strings.Join(nuageUnderlayACLEntryIDsMap[nuageDomain.ID], ","))
+				findings = append(findings, finding)
+			}
+			continue
+		}
+
+		expected := nuageDisabled
+		if neutronRouterGateway.EnableSnat {
+			expected = nuageEnabled
+		}
+		if nuageDomain.PATEnabled != expected {
+			finding.Code = CodeDomainPATMismatch
+			finding.Message = fmt.Sprintf("domain %s has PATEnabled %s but enable_snat of router %s is %t",
+				nuageDomain.ID, nuageDomain.PATEnabled, neutronRouterID, neutronRouterGateway.EnableSnat)
+			findings = append(findings, finding)
+		}
+		if nuageDomain.UnderlayEnabled != expected {
+			finding.Code = CodeDomainUnderlayMismatch
+			finding.Message = fmt.Sprintf("domain %s has underlayEnabled %s but enable_snat of router %s is %t",
+				nuageDomain.ID, nuageDomain.UnderlayEnabled, neutronRouterID, neutronRouterGateway.EnableSnat)
+			findings = append(findings, finding)
+		}
+		if expected == nuageDisabled && len(nuageUnderlayACLEntryIDsMap[nuageDomain.ID]) > 0 {
+			finding.Code = CodeUnderlayACLEntryUnexpected
+			finding.Message = fmt.Sprintf("domain %s has underlay acl entries %s but enable_snat of router %s is false",
+				nuageDomain.ID, strings.Join(nuageUnderlayACLEntryIDsMap[nuageDomain.ID], ","), neutronRouterID)
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings
+}
+
+func scanResForUnderlayAcl() ([]Finding, error) {
+	err := dumpAllNeutronUnderlayResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNeutronUnderlayResources: %s", err)
+	}
+
+	err = dumpAllNuageUnderlayResources()
+	if err != nil {
+		return nil, fmt.Errorf("dumpAllNuageUnderlayResources: %s", err)
+	}
+
+	findings := scanResForUnderlayAclBaseOnNeutron()
+	findings = append(findings, scanResForUnderlayAclBaseOnNuage()...)
+	return findings, nil
 }
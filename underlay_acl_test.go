@@ -0,0 +1,129 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/nuagenetworks/vspk-go/vspk"
+	"reflect"
+	"testing"
+)
+
+func TestScanResForUnderlayAcl(t *testing.T) {
+	// Domain d1 of router r1 has an underlay acl entry in its ingress acl template
+	ingressACLTemplates := vspk.IngressACLTemplatesList{{ID: "acl1", ParentType: vspk.DomainIdentity.Name, ParentID: "d1"}}
+	underlayEntries := vspk.IngressACLEntryTemplatesList{{ID: "u1", ParentID: "acl1", NetworkType: underlayNetworkType}}
+	gateway := func(enableSnat bool) []RouterGateway {
+		return []RouterGateway{{ID: "r1", GwPortID: nullString("p-gw"), EnableSnat: enableSnat}}
+	}
+	underlay := func(value string) []NuageSubnetParameter {
+		return []NuageSubnetParameter{{SubnetID: "s1", ParameterName: "nuage_underlay", ParameterValue: value}}
+	}
+
+	for _, test := range []struct {
+		name       string
+		parameters []NuageSubnetParameter
+		gateways   []RouterGateway
+		subnet     *vspk.Subnet
+		domain     *vspk.Domain
+		entries    vspk.IngressACLEntryTemplatesList
+		expected   []string
+	}{
+		{
+			name:       "subnet snat",
+			parameters: underlay("snat"),
+			subnet:     &vspk.Subnet{UnderlayEnabled: nuageEnabled, PATEnabled: nuageEnabled},
+			expected:   []string{},
+		},
+		{
+			name:       "subnet route with PAT",
+			parameters: underlay("route"),
+			subnet:     &vspk.Subnet{UnderlayEnabled: nuageEnabled, PATEnabled: nuageEnabled},
+			expected:   []string{"subnet-pat-mismatch s1 n1 bj"},
+		},
+		{
+			name:       "subnet off with underlay",
+			parameters: underlay("off"),
+			subnet:     &vspk.Subnet{UnderlayEnabled: nuageEnabled, PATEnabled: nuageDisabled},
+			expected:   []string{"subnet-underlay-mismatch s1 n1 bj"},
+		},
+		{
+			name:       "subnet of another value",
+			parameters: underlay("inherited"),
+			subnet:     &vspk.Subnet{UnderlayEnabled: nuageInherited, PATEnabled: nuageDisabled},
+			expected:   []string{"subnet-pat-mismatch s1 n1 bj"},
+		},
+		{
+			name:     "router snat",
+			gateways: gateway(true),
+			domain:   &vspk.Domain{PATEnabled: nuageEnabled, UnderlayEnabled: nuageEnabled},
+			entries:  underlayEntries,
+			expected: []string{},
+		},
+		{
+			name:     "router without snat",
+			gateways: gateway(false),
+			domain:   &vspk.Domain{PATEnabled: nuageEnabled, UnderlayEnabled: nuageEnabled},
+			entries:  underlayEntries,
+			expected: []string{"domain-pat-mismatch r1 d1 bj", "domain-underlay-mismatch r1 d1 bj", "underlay-acl-entry-unexpected r1 d1 bj"},
+		},
+		{
+			name:     "router without gateway",
+			domain:   &vspk.Domain{PATEnabled: nuageDisabled, UnderlayEnabled: nuageDisabled},
+			entries:  underlayEntries,
+			expected: []string{"underlay-acl-entry-unexpected r1 d1 bj"},
+		},
+		{
+			name:     "router without gateway with underlay",
+			domain:   &vspk.Domain{PATEnabled: nuageDisabled, UnderlayEnabled: nuageEnabled},
+			entries:  underlayEntries,
+			expected: []string{},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			vsdSnapshot := VsdSnapshot{AZ: "bj", IngressACLTemplates: ingressACLTemplates, IngressACLEntryTemplates: test.entries}
+			if test.subnet != nil {
+				test.subnet.ID = "n1"
+				test.subnet.ExternalID = "s1@cms-bj"
+				vsdSnapshot.Subnets = vspk.SubnetsList{test.subnet}
+			}
+			if test.domain != nil {
+				test.domain.ID = "d1"
+				test.domain.ExternalID = "r1@cms-bj"
+				vsdSnapshot.Domains = vspk.DomainsList{test.domain}
+			}
+			loadTestSnapshot(t, &Snapshot{
+				Config: Config{Vsds: testVsds},
+				Neutron: NeutronSnapshot{
+					Subnets: []Subnet{{ID: "s1"}},
+					NuageSubnetL2domMappings: []NuageSubnetL2domMapping{
+						{SubnetID: "s1", NuageSubnetID: "n1", NetPartitionName: nullString("OpenStack_bj")},
+					},
+					RouterGateways:        test.gateways,
+					NuageSubnetParameters: test.parameters,
+				},
+				Vsds: []VsdSnapshot{vsdSnapshot, {AZ: "cs"}},
+			})
+
+			findings, err := scanResForUnderlayAcl()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := findingKeys(findings); !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("findings %q, expected %q", keys, test.expected)
+			}
+		})
+	}
+}
@@ -19,32 +19,120 @@ import (
 	"fmt"
 	"github.com/nuagenetworks/go-bambou/bambou"
 	"github.com/nuagenetworks/vspk-go/vspk"
+	"sync"
 )
 
 const maxPageSize = 500
 
-func StartSession(username string, password string, organization string, url string) (*vspk.Me, error) {
+// VsdSession is an authenticated session on the net partition of one VSD.
+// vspk keeps a single global session, so children are always fetched
+// through the session of the VsdSession instead of the vspk helpers, and
+// the vspk calls on objects are made with withCurrentSession.
+type VsdSession struct {
+	VSD        *VSD
+	Session    *bambou.Session
+	Me         *vspk.Me
+	Enterprise *vspk.Enterprise
+}
+
+// VSD sessions of the run, keyed by AZ
+var vsdSessions = make(map[string]*VsdSession)
+var vsdSessionsMutex sync.Mutex
+
+// Guards the global session of vspk, which is the session started last
+var currentSessionMutex sync.Mutex
+
+func StartSession(username string, password string, organization string, url string) (*bambou.Session, *vspk.Me, error) {
 	session, me := vspk.NewSession(username, password, organization, url)
+	currentSessionMutex.Lock()
+	defer currentSessionMutex.Unlock()
 	err := session.Start()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return me, nil
+	return session, me, nil
 }
 
-func FetchEnterpriseByName(me *vspk.Me, name string) (*vspk.Enterprise, error) {
+func FetchEnterpriseByName(session *bambou.Session, me *vspk.Me, name string) (*vspk.Enterprise, error) {
 	filter := fmt.Sprintf("name == '%s'", name)
-	enterprises, err := me.Enterprises(&bambou.FetchingInfo{Filter: filter})
+	var enterprises vspk.EnterprisesList
+	err := session.FetchChildren(me, vspk.EnterpriseIdentity, &enterprises, &bambou.FetchingInfo{Filter: filter})
 	if err != nil {
 		return nil, fmt.Errorf("%s", err.Error())
 	}
+	if len(enterprises) <= 0 {
+		return nil, fmt.Errorf("enterprise %s was not found", name)
+	}
 	return enterprises[0], nil
 }
 
-func FetchAllL2DomainTemplates(enterprise *vspk.Enterprise) (vspk.L2DomainTemplatesList, error) {
+func resetVsdSessions() {
+	vsdSessionsMutex.Lock()
+	defer vsdSessionsMutex.Unlock()
+
+	vsdSessions = make(map[string]*VsdSession)
+}
+
+// GetVsdSession returns the session of the VSD, logging in on first use
+func GetVsdSession(vsd *VSD) (*VsdSession, error) {
+	vsdSessionsMutex.Lock()
+	defer vsdSessionsMutex.Unlock()
+
+	vsdSession := vsdSessions[vsd.AZ]
+	if vsdSession != nil {
+		return vsdSession, nil
+	}
+
+	session, me, err := StartSession(vsd.Username, vsd.Password, vsd.Organization, vsd.URL)
+	recordVsdAPICall(vsd.AZ, err != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	enterprise, err := FetchEnterpriseByName(session, me, vsd.NetPartition)
+	recordVsdAPICall(vsd.AZ, err != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	vsdSession = &VsdSession{VSD: vsd, Session: session, Me: me, Enterprise: enterprise}
+	vsdSessions[vsd.AZ] = vsdSession
+	return vsdSession, nil
+}
+
+// withCurrentSession makes the session the global session of vspk, logging in
+// again when another session was started since, and runs the vspk call
+func (s *VsdSession) withCurrentSession(call func() *bambou.Error) error {
+	currentSessionMutex.Lock()
+	defer currentSessionMutex.Unlock()
+
+	if bambou.CurrentSession() != s.Session {
+		err := s.Session.Start()
+		s.recordAPICall(err)
+		if err != nil {
+			return fmt.Errorf("%s", err.Error())
+		}
+	}
+
+	err := call()
+	s.recordAPICall(err)
+	if err != nil {
+		return fmt.Errorf("%s", err.Error())
+	}
+	return nil
+}
+
+// recordAPICall counts a call to the VSD API in the metrics
+func (s *VsdSession) recordAPICall(err *bambou.Error) {
+	recordVsdAPICall(s.VSD.AZ, err != nil)
+}
+
+func (s *VsdSession) FetchAllL2DomainTemplates(filter string) (vspk.L2DomainTemplatesList, error) {
 	var allL2DomainTemplates vspk.L2DomainTemplatesList
 	for page := 0; ; page++ {
-		l2DomainTemplates, err := enterprise.L2DomainTemplates(&bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		var l2DomainTemplates vspk.L2DomainTemplatesList
+		err := s.Session.FetchChildren(s.Enterprise, vspk.L2DomainTemplateIdentity, &l2DomainTemplates, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
 		if err != nil {
 			return nil, fmt.Errorf("%s", err.Error())
 		}
@@ -57,10 +145,12 @@ func FetchAllL2DomainTemplates(enterprise *vspk.Enterprise) (vspk.L2DomainTempla
 	return allL2DomainTemplates, nil
 }
 
-func FetchAllL2Domains(enterprise *vspk.Enterprise) (vspk.L2DomainsList, error) {
+func (s *VsdSession) FetchAllL2Domains() (vspk.L2DomainsList, error) {
 	var allL2Domains vspk.L2DomainsList
 	for page := 0; ; page++ {
-		l2Domains, err := enterprise.L2Domains(&bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		var l2Domains vspk.L2DomainsList
+		err := s.Session.FetchChildren(s.Enterprise, vspk.L2DomainIdentity, &l2Domains, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
 		if err != nil {
 			return nil, fmt.Errorf("%s", err.Error())
 		}
@@ -73,10 +163,12 @@ func FetchAllL2Domains(enterprise *vspk.Enterprise) (vspk.L2DomainsList, error)
 	return allL2Domains, nil
 }
 
-func FetchAllDomains(enterprise *vspk.Enterprise) (vspk.DomainsList, error) {
+func (s *VsdSession) FetchAllDomains() (vspk.DomainsList, error) {
 	var allDomains vspk.DomainsList
 	for page := 0; ; page++ {
-		domains, err := enterprise.Domains(&bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		var domains vspk.DomainsList
+		err := s.Session.FetchChildren(s.Enterprise, vspk.DomainIdentity, &domains, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
 		if err != nil {
 			return nil, fmt.Errorf("%s", err.Error())
 		}
@@ -89,10 +181,12 @@ func FetchAllDomains(enterprise *vspk.Enterprise) (vspk.DomainsList, error) {
 	return allDomains, nil
 }
 
-func FetchAllSubnets(domain *vspk.Domain) (vspk.SubnetsList, error) {
+func (s *VsdSession) FetchAllSubnets(domain *vspk.Domain, filter string) (vspk.SubnetsList, error) {
 	var allSubnets vspk.SubnetsList
 	for page := 0; ; page++ {
-		subnets, err := domain.Subnets(&bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		var subnets vspk.SubnetsList
+		err := s.Session.FetchChildren(domain, vspk.SubnetIdentity, &subnets, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
 		if err != nil {
 			return nil, fmt.Errorf("%s", err.Error())
 		}
@@ -104,3 +198,255 @@ func FetchAllSubnets(domain *vspk.Domain) (vspk.SubnetsList, error) {
 
 	return allSubnets, nil
 }
+
+func (s *VsdSession) FetchAllDomainVPorts(domain *vspk.Domain, filter string) (vspk.VPortsList, error) {
+	var allVPorts vspk.VPortsList
+	for page := 0; ; page++ {
+		var vports vspk.VPortsList
+		err := s.Session.FetchChildren(domain, vspk.VPortIdentity, &vports, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if vports == nil {
+			break
+		}
+		allVPorts = append(allVPorts, vports...)
+	}
+
+	return allVPorts, nil
+}
+
+func (s *VsdSession) FetchAllL2DomainVPorts(l2Domain *vspk.L2Domain, filter string) (vspk.VPortsList, error) {
+	var allVPorts vspk.VPortsList
+	for page := 0; ; page++ {
+		var vports vspk.VPortsList
+		err := s.Session.FetchChildren(l2Domain, vspk.VPortIdentity, &vports, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if vports == nil {
+			break
+		}
+		allVPorts = append(allVPorts, vports...)
+	}
+
+	return allVPorts, nil
+}
+
+func (s *VsdSession) FetchAllDomainVMInterfaces(domain *vspk.Domain, filter string) (vspk.VMInterfacesList, error) {
+	var allVMInterfaces vspk.VMInterfacesList
+	for page := 0; ; page++ {
+		var vmInterfaces vspk.VMInterfacesList
+		err := s.Session.FetchChildren(domain, vspk.VMInterfaceIdentity, &vmInterfaces, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if vmInterfaces == nil {
+			break
+		}
+		allVMInterfaces = append(allVMInterfaces, vmInterfaces...)
+	}
+
+	return allVMInterfaces, nil
+}
+
+func (s *VsdSession) FetchAllL2DomainVMInterfaces(l2Domain *vspk.L2Domain, filter string) (vspk.VMInterfacesList, error) {
+	var allVMInterfaces vspk.VMInterfacesList
+	for page := 0; ; page++ {
+		var vmInterfaces vspk.VMInterfacesList
+		err := s.Session.FetchChildren(l2Domain, vspk.VMInterfaceIdentity, &vmInterfaces, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if vmInterfaces == nil {
+			break
+		}
+		allVMInterfaces = append(allVMInterfaces, vmInterfaces...)
+	}
+
+	return allVMInterfaces, nil
+}
+
+func (s *VsdSession) FetchAllDomainPolicyGroups(domain *vspk.Domain, filter string) (vspk.PolicyGroupsList, error) {
+	var allPolicyGroups vspk.PolicyGroupsList
+	for page := 0; ; page++ {
+		var policyGroups vspk.PolicyGroupsList
+		err := s.Session.FetchChildren(domain, vspk.PolicyGroupIdentity, &policyGroups, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if policyGroups == nil {
+			break
+		}
+		allPolicyGroups = append(allPolicyGroups, policyGroups...)
+	}
+
+	return allPolicyGroups, nil
+}
+
+func (s *VsdSession) FetchAllL2DomainPolicyGroups(l2Domain *vspk.L2Domain, filter string) (vspk.PolicyGroupsList, error) {
+	var allPolicyGroups vspk.PolicyGroupsList
+	for page := 0; ; page++ {
+		var policyGroups vspk.PolicyGroupsList
+		err := s.Session.FetchChildren(l2Domain, vspk.PolicyGroupIdentity, &policyGroups, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if policyGroups == nil {
+			break
+		}
+		allPolicyGroups = append(allPolicyGroups, policyGroups...)
+	}
+
+	return allPolicyGroups, nil
+}
+
+func (s *VsdSession) FetchAllDomainIngressACLTemplates(domain *vspk.Domain) (vspk.IngressACLTemplatesList, error) {
+	var allIngressACLTemplates vspk.IngressACLTemplatesList
+	for page := 0; ; page++ {
+		var ingressACLTemplates vspk.IngressACLTemplatesList
+		err := s.Session.FetchChildren(domain, vspk.IngressACLTemplateIdentity, &ingressACLTemplates, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if ingressACLTemplates == nil {
+			break
+		}
+		allIngressACLTemplates = append(allIngressACLTemplates, ingressACLTemplates...)
+	}
+
+	return allIngressACLTemplates, nil
+}
+
+func (s *VsdSession) FetchAllL2DomainIngressACLTemplates(l2Domain *vspk.L2Domain) (vspk.IngressACLTemplatesList, error) {
+	var allIngressACLTemplates vspk.IngressACLTemplatesList
+	for page := 0; ; page++ {
+		var ingressACLTemplates vspk.IngressACLTemplatesList
+		err := s.Session.FetchChildren(l2Domain, vspk.IngressACLTemplateIdentity, &ingressACLTemplates, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if ingressACLTemplates == nil {
+			break
+		}
+		allIngressACLTemplates = append(allIngressACLTemplates, ingressACLTemplates...)
+	}
+
+	return allIngressACLTemplates, nil
+}
+
+func (s *VsdSession) FetchAllDomainEgressACLTemplates(domain *vspk.Domain) (vspk.EgressACLTemplatesList, error) {
+	var allEgressACLTemplates vspk.EgressACLTemplatesList
+	for page := 0; ; page++ {
+		var egressACLTemplates vspk.EgressACLTemplatesList
+		err := s.Session.FetchChildren(domain, vspk.EgressACLTemplateIdentity, &egressACLTemplates, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if egressACLTemplates == nil {
+			break
+		}
+		allEgressACLTemplates = append(allEgressACLTemplates, egressACLTemplates...)
+	}
+
+	return allEgressACLTemplates, nil
+}
+
+func (s *VsdSession) FetchAllL2DomainEgressACLTemplates(l2Domain *vspk.L2Domain) (vspk.EgressACLTemplatesList, error) {
+	var allEgressACLTemplates vspk.EgressACLTemplatesList
+	for page := 0; ; page++ {
+		var egressACLTemplates vspk.EgressACLTemplatesList
+		err := s.Session.FetchChildren(l2Domain, vspk.EgressACLTemplateIdentity, &egressACLTemplates, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if egressACLTemplates == nil {
+			break
+		}
+		allEgressACLTemplates = append(allEgressACLTemplates, egressACLTemplates...)
+	}
+
+	return allEgressACLTemplates, nil
+}
+
+func (s *VsdSession) FetchAllIngressACLEntryTemplates(ingressACLTemplate *vspk.IngressACLTemplate) (vspk.IngressACLEntryTemplatesList, error) {
+	var allIngressACLEntryTemplates vspk.IngressACLEntryTemplatesList
+	for page := 0; ; page++ {
+		var ingressACLEntryTemplates vspk.IngressACLEntryTemplatesList
+		err := s.Session.FetchChildren(ingressACLTemplate, vspk.IngressACLEntryTemplateIdentity, &ingressACLEntryTemplates, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if ingressACLEntryTemplates == nil {
+			break
+		}
+		allIngressACLEntryTemplates = append(allIngressACLEntryTemplates, ingressACLEntryTemplates...)
+	}
+
+	return allIngressACLEntryTemplates, nil
+}
+
+func (s *VsdSession) FetchAllEgressACLEntryTemplates(egressACLTemplate *vspk.EgressACLTemplate) (vspk.EgressACLEntryTemplatesList, error) {
+	var allEgressACLEntryTemplates vspk.EgressACLEntryTemplatesList
+	for page := 0; ; page++ {
+		var egressACLEntryTemplates vspk.EgressACLEntryTemplatesList
+		err := s.Session.FetchChildren(egressACLTemplate, vspk.EgressACLEntryTemplateIdentity, &egressACLEntryTemplates, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if egressACLEntryTemplates == nil {
+			break
+		}
+		allEgressACLEntryTemplates = append(allEgressACLEntryTemplates, egressACLEntryTemplates...)
+	}
+
+	return allEgressACLEntryTemplates, nil
+}
+
+func (s *VsdSession) FetchAllSharedNetworkResources() (vspk.SharedNetworkResourcesList, error) {
+	var allSharedNetworkResources vspk.SharedNetworkResourcesList
+	for page := 0; ; page++ {
+		var sharedNetworkResources vspk.SharedNetworkResourcesList
+		err := s.Session.FetchChildren(s.Me, vspk.SharedNetworkResourceIdentity, &sharedNetworkResources, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if sharedNetworkResources == nil {
+			break
+		}
+		allSharedNetworkResources = append(allSharedNetworkResources, sharedNetworkResources...)
+	}
+
+	return allSharedNetworkResources, nil
+}
+
+func (s *VsdSession) FetchAllDomainFloatingIps(domain *vspk.Domain, filter string) (vspk.FloatingIpsList, error) {
+	var allFloatingIps vspk.FloatingIpsList
+	for page := 0; ; page++ {
+		var floatingIps vspk.FloatingIpsList
+		err := s.Session.FetchChildren(domain, vspk.FloatingIpIdentity, &floatingIps, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize, Filter: filter})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if floatingIps == nil {
+			break
+		}
+		allFloatingIps = append(allFloatingIps, floatingIps...)
+	}
+
+	return allFloatingIps, nil
+}
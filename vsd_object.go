@@ -0,0 +1,274 @@
+// Copyright (C) 2021 Nokia-Sbell Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nuagenetworks/go-bambou/bambou"
+	"github.com/nuagenetworks/vspk-go/vspk"
+)
+
+// VsdObject is a VSD object of any type kept as raw JSON attributes, so that
+// attributes vspk does not know about, like lastUpdatedDate, are available
+type VsdObject struct {
+	identity   bambou.Identity
+	ID         string
+	Attributes map[string]interface{}
+}
+
+func NewVsdObject(identity bambou.Identity, id string) *VsdObject {
+	return &VsdObject{identity: identity, ID: id}
+}
+
+func (o *VsdObject) Identity() bambou.Identity {
+	return o.identity
+}
+
+func (o *VsdObject) Identifier() string {
+	return o.ID
+}
+
+func (o *VsdObject) SetIdentifier(ID string) {
+	o.ID = ID
+}
+
+func (o *VsdObject) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Attributes)
+}
+
+func (o *VsdObject) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&o.Attributes)
+	if err != nil {
+		return err
+	}
+	if ID, ok := o.Attributes["ID"].(string); ok {
+		o.ID = ID
+	}
+	return nil
+}
+
+func (o *VsdObject) ExternalID() string {
+	externalID, _ := o.Attributes["externalID"].(string)
+	return externalID
+}
+
+// LastUpdatedDate returns the last update of the object in milliseconds since the epoch
+func (o *VsdObject) LastUpdatedDate() int64 {
+	number, _ := o.Attributes["lastUpdatedDate"].(json.Number)
+	lastUpdatedDate, _ := number.Int64()
+	return lastUpdatedDate
+}
+
+// newVspkObject returns an empty vspk object of the identity
+func newVspkObject(identity bambou.Identity) (bambou.Identifiable, error) {
+	switch identity.Name {
+	case vspk.EnterpriseIdentity.Name:
+		return &vspk.Enterprise{}, nil
+	case vspk.L2DomainTemplateIdentity.Name:
+		return &vspk.L2DomainTemplate{}, nil
+	case vspk.L2DomainIdentity.Name:
+		return &vspk.L2Domain{}, nil
+	case vspk.DomainTemplateIdentity.Name:
+		return &vspk.DomainTemplate{}, nil
+	case vspk.DomainIdentity.Name:
+		return &vspk.Domain{}, nil
+	case vspk.ZoneIdentity.Name:
+		return &vspk.Zone{}, nil
+	case vspk.SubnetIdentity.Name:
+		return &vspk.Subnet{}, nil
+	case vspk.VPortIdentity.Name:
+		return &vspk.VPort{}, nil
+	case vspk.VMIdentity.Name:
+		return &vspk.VM{}, nil
+	case vspk.VMInterfaceIdentity.Name:
+		return &vspk.VMInterface{}, nil
+	case vspk.HostInterfaceIdentity.Name:
+		return &vspk.HostInterface{}, nil
+	case vspk.BridgeInterfaceIdentity.Name:
+		return &vspk.BridgeInterface{}, nil
+	case vspk.PolicyGroupIdentity.Name:
+		return &vspk.PolicyGroup{}, nil
+	case vspk.FloatingIpIdentity.Name:
+		return &vspk.FloatingIp{}, nil
+	case vspk.IngressACLTemplateIdentity.Name:
+		return &vspk.IngressACLTemplate{}, nil
+	case vspk.EgressACLTemplateIdentity.Name:
+		return &vspk.EgressACLTemplate{}, nil
+	case vspk.IngressACLEntryTemplateIdentity.Name:
+		return &vspk.IngressACLEntryTemplate{}, nil
+	case vspk.EgressACLEntryTemplateIdentity.Name:
+		return &vspk.EgressACLEntryTemplate{}, nil
+	}
+	return nil, fmt.Errorf("unknown object type %s", identity.Name)
+}
+
+// VspkObject returns the object as its vspk type, with the attributes vspk knows about
+func (o *VsdObject) VspkObject() (bambou.Identifiable, error) {
+	object, err := newVspkObject(o.identity)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(o.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s %s: %s", o.identity.Name, o.ID, err)
+	}
+	object.SetIdentifier(o.ID)
+	return object, nil
+}
+
+// FetchObject fetches the object of the given type and ID with all its attributes
+func (s *VsdSession) FetchObject(identity bambou.Identity, ID string) (*VsdObject, error) {
+	object := NewVsdObject(identity, ID)
+	err := s.Session.FetchEntity(object)
+	s.recordAPICall(err)
+	if err != nil {
+		return nil, fmt.Errorf("%s", err.Error())
+	}
+	return object, nil
+}
+
+// FetchChildObjects fetches all children of the given type of the parent with all their attributes
+func (s *VsdSession) FetchChildObjects(parent *VsdObject, identity bambou.Identity) ([]*VsdObject, error) {
+	var allObjects []*VsdObject
+	for page := 0; ; page++ {
+		var objects []*VsdObject
+		err := s.Session.FetchChildren(parent, identity, &objects, &bambou.FetchingInfo{Page: page, PageSize: maxPageSize})
+		s.recordAPICall(err)
+		if err != nil {
+			return nil, fmt.Errorf("%s", err.Error())
+		}
+		if objects == nil {
+			break
+		}
+		for _, object := range objects {
+			object.identity = identity
+		}
+		allObjects = append(allObjects, objects...)
+	}
+
+	return allObjects, nil
+}
+
+// vspkCreateFunc returns the Create method of the vspk parent creating the child
+func vspkCreateFunc(parent bambou.Identifiable, child bambou.Identifiable) (func() *bambou.Error, error) {
+	switch p := parent.(type) {
+	case *vspk.Enterprise:
+		switch c := child.(type) {
+		case *vspk.Domain:
+			return func() *bambou.Error { return p.CreateDomain(c) }, nil
+		case *vspk.L2Domain:
+			return func() *bambou.Error { return p.CreateL2Domain(c) }, nil
+		case *vspk.L2DomainTemplate:
+			return func() *bambou.Error { return p.CreateL2DomainTemplate(c) }, nil
+		case *vspk.DomainTemplate:
+			return func() *bambou.Error { return p.CreateDomainTemplate(c) }, nil
+		}
+	case *vspk.Domain:
+		switch c := child.(type) {
+		case *vspk.Zone:
+			return func() *bambou.Error { return p.CreateZone(c) }, nil
+		case *vspk.PolicyGroup:
+			return func() *bambou.Error { return p.CreatePolicyGroup(c) }, nil
+		case *vspk.FloatingIp:
+			return func() *bambou.Error { return p.CreateFloatingIp(c) }, nil
+		case *vspk.IngressACLTemplate:
+			return func() *bambou.Error { return p.CreateIngressACLTemplate(c) }, nil
+		case *vspk.EgressACLTemplate:
+			return func() *bambou.Error { return p.CreateEgressACLTemplate(c) }, nil
+		}
+	case *vspk.L2Domain:
+		switch c := child.(type) {
+		case *vspk.VPort:
+			return func() *bambou.Error { return p.CreateVPort(c) }, nil
+		case *vspk.PolicyGroup:
+			return func() *bambou.Error { return p.CreatePolicyGroup(c) }, nil
+		case *vspk.IngressACLTemplate:
+			return func() *bambou.Error { return p.CreateIngressACLTemplate(c) }, nil
+		case *vspk.EgressACLTemplate:
+			return func() *bambou.Error { return p.CreateEgressACLTemplate(c) }, nil
+		}
+	case *vspk.Zone:
+		if c, ok := child.(*vspk.Subnet); ok {
+			return func() *bambou.Error { return p.CreateSubnet(c) }, nil
+		}
+	case *vspk.Subnet:
+		if c, ok := child.(*vspk.VPort); ok {
+			return func() *bambou.Error { return p.CreateVPort(c) }, nil
+		}
+	case *vspk.VPort:
+		switch c := child.(type) {
+		case *vspk.VMInterface:
+			return func() *bambou.Error { return p.CreateVMInterface(c) }, nil
+		case *vspk.HostInterface:
+			return func() *bambou.Error { return p.CreateHostInterface(c) }, nil
+		case *vspk.BridgeInterface:
+			return func() *bambou.Error { return p.CreateBridgeInterface(c) }, nil
+		}
+	case *vspk.IngressACLTemplate:
+		if c, ok := child.(*vspk.IngressACLEntryTemplate); ok {
+			return func() *bambou.Error { return p.CreateIngressACLEntryTemplate(c) }, nil
+		}
+	case *vspk.EgressACLTemplate:
+		if c, ok := child.(*vspk.EgressACLEntryTemplate); ok {
+			return func() *bambou.Error { return p.CreateEgressACLEntryTemplate(c) }, nil
+		}
+	}
+	return nil, fmt.Errorf("%s cannot be created under %s", child.Identity().Name, parent.Identity().Name)
+}
+
+// CreateObject creates the object under the parent with the Create method of the
+// vspk parent, the object gets the ID returned by VSD
+func (s *VsdSession) CreateObject(parent *VsdObject, object *VsdObject) error {
+	vspkParent, err := parent.VspkObject()
+	if err != nil {
+		return err
+	}
+	vspkObject, err := object.VspkObject()
+	if err != nil {
+		return err
+	}
+	create, err := vspkCreateFunc(vspkParent, vspkObject)
+	if err != nil {
+		return err
+	}
+	err = s.withCurrentSession(create)
+	if err != nil {
+		return err
+	}
+	object.ID = vspkObject.Identifier()
+	return nil
+}
+
+// DeleteObject deletes the object with the Delete of its vspk type
+func (s *VsdSession) DeleteObject(object *VsdObject) error {
+	vspkObject, err := object.VspkObject()
+	if err != nil {
+		return err
+	}
+	deletable, ok := vspkObject.(interface{ Delete() *bambou.Error })
+	if !ok {
+		return fmt.Errorf("%s cannot be deleted", object.Identity().Name)
+	}
+	return s.withCurrentSession(deletable.Delete)
+}